@@ -38,7 +38,9 @@ func StartDemoServer(
 			if err != nil {
 				log.Fatal(path, err)
 			}
-			fsd.Install(leader)
+			if err := fsd.Install(leader); err != nil {
+				log.Fatal(path, err)
+			}
 
 			go startDemo(lis, httpd, fsd)
 
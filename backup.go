@@ -0,0 +1,152 @@
+// Online backup and restore.
+//
+// Backup is a JSON document carrying the dictionary, as saved in raft
+// snapshots, along with its CAS, raft term and the index of the last raft
+// command applied to it. Commands are applied under the same lock that
+// serialises the backup, hence the index exactly covers the backup.
+// Restore replaces the dictionary of a fresh cluster, one that has not seen
+// any writes, through raft so that all nodes are restored. Backup is
+// validated before it is replicated.
+
+package failsafe
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/goraft/raft"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// ErrorNotFresh when restoring a backup on a dictionary that has data.
+var ErrorNotFresh = fmt.Errorf("safedict.errorNotFresh")
+
+// ErrorInvalidBackup when backup to restore cannot be decoded.
+var ErrorInvalidBackup = fmt.Errorf("failsafe.errorInvalidBackup")
+
+// Backup of fail-safe dictionary.
+type Backup struct {
+	Term  uint64          `json:"term"`  // raft term at the time of backup.
+	Index uint64          `json:"index"` // raft index of the last applied command.
+	CAS   float64         `json:"CAS"`
+	Dict  json.RawMessage `json:"dict"`
+}
+
+// Backup return the dictionary, as saved in snapshots, and its CAS.
+func (sd *SafeDict) Backup() (data []byte, CAS float64, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if data, err = json.Marshal(sd); err != nil {
+		return nil, nullCAS, err
+	}
+	return data, sd.CAS, nil
+}
+
+// Restore dictionary from backup, dictionary shall be fresh without any
+// fields, leases or writes. Watchers are dropped, like in Recovery.
+func (sd *SafeDict) Restore(data []byte) (nextCAS float64, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if sd.CAS > 1 || len(sd.m) > 0 || len(sd.leases.leases) > 0 {
+		return nullCAS, ErrorNotFresh
+	}
+	restored := &SafeDict{}
+	if err = json.Unmarshal(data, restored); err != nil {
+		return nullCAS, err
+	}
+	sd.load(restored)
+	return sd.CAS, nil
+}
+
+// load content of restored dictionary, history and older versions are
+// dropped while their sizes are preserved, watchers are dropped too.
+func (sd *SafeDict) load(restored *SafeDict) {
+	sd.m, sd.CAS, sd.revs = restored.m, restored.CAS, restored.revs
+	sd.leases = restored.leases
+	if sd.m == nil {
+		sd.m = make(map[string]interface{})
+	}
+	if sd.leases == nil {
+		sd.leases = newLeases(0, nil)
+	}
+	sd.history = newHistory(len(sd.history.changes), sd.CAS)
+	sd.mvcc = newMVCC(sd.CAS, sd.mvcc.window)
+	sd.promoted = false
+	for id, w := range sd.watchers {
+		delete(sd.watchers, id)
+		close(w.ch)
+	}
+}
+
+// Backup writes a consistent backup of the dictionary to w.
+func (s *Server) Backup(w io.Writer) error {
+	s.applyMu.Lock()
+	term, index := s.raftServer.Term(), s.applyIndex
+	data, CAS, err := s.db.Backup()
+	s.applyMu.Unlock()
+	if err != nil {
+		return err
+	}
+	backup := Backup{Term: term, Index: index, CAS: CAS, Dict: data}
+	return json.NewEncoder(w).Encode(&backup)
+}
+
+// Restore backup read from r, on all nodes. Cluster shall be fresh, that
+// is, the dictionary shall not have seen any writes.
+func (s *Server) Restore(r io.Reader) (nextCAS float64, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nullCAS, err
+	}
+	var backup Backup
+	if err = json.Unmarshal(data, &backup); err != nil {
+		return nullCAS, ErrorInvalidBackup
+	}
+	restored := &SafeDict{}
+	if err = json.Unmarshal(backup.Dict, restored); err != nil {
+		return nullCAS, ErrorInvalidBackup
+	} else if restored.CAS != backup.CAS {
+		return nullCAS, ErrorInvalidBackup
+	}
+	val, err := s.raftServer.Do(NewRestoreCommand(backup.Dict))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
+// apply a raft command, return a function to be called once the command is
+// applied, ok is false if the command is already applied and shall be
+// skipped. Commands, backups and snapshots are serialised, and the index
+// of the last applied command is recorded for them and for status.
+func (s *Server) apply(context raft.Context) (applied func(), ok bool) {
+	s.applyMu.Lock()
+	index := entryIndex(context)
+	if index <= s.applyIndex { // replayed from log, refer snapshot.go.
+		s.applyMu.Unlock()
+		return func() {}, false
+	}
+	s.db.discardPromoted()
+	start := time.Now()
+	return func() {
+		s.applyIndex = index
+		s.setAppliedIndex(index)
+		s.setRecovered(false)
+		s.applyMu.Unlock()
+		s.metrics.apply.since(start)
+	}, true
+}
+
+// entryIndex of the command being applied. goraft passes the index of its
+// last log entry as current index and the index being committed as commit
+// index, except while replaying the log on start, when the last log entry
+// is the one being applied and commit index is the last committed index.
+func entryIndex(context raft.Context) uint64 {
+	if index := context.CommitIndex(); index < context.CurrentIndex() {
+		return index
+	}
+	return context.CurrentIndex()
+}
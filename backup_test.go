@@ -0,0 +1,90 @@
+package failsafe
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupRestore(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"users": [{"name": "x"}]}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sd.Set("/users/0/eyeColor", "brown", nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	leaseID := sd.GrantLease(time.Second)
+	data, CAS, err := sd.Backup()
+	if err != nil {
+		t.Fatal(err)
+	} else if CAS != sd.GetCAS() {
+		t.Fatalf("expected %v, got %v", sd.GetCAS(), CAS)
+	}
+
+	// restore is refused on a dictionary with data.
+	if _, err := sd.Restore(data); err != ErrorNotFresh {
+		t.Fatalf("expected %v, got %v", ErrorNotFresh, err)
+	}
+
+	fresh, err := NewSafeDict(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextCAS, err := fresh.Restore(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if nextCAS != CAS {
+		t.Fatalf("expected %v, got %v", CAS, nextCAS)
+	}
+	value, _, rev, err := fresh.GetRev("/users/0/eyeColor")
+	if err != nil {
+		t.Fatal(err)
+	} else if value != "brown" {
+		t.Fatalf("unexpected value %v", value)
+	} else if _, _, ref, _ := sd.GetRev("/users/0/eyeColor"); !reflect.DeepEqual(rev, ref) {
+		t.Fatalf("expected %v, got %v", ref, rev)
+	}
+	if ttl, err := fresh.GetLeaseTTL(leaseID); err != nil || ttl != time.Second {
+		t.Fatalf("expected lease %v, got %v %v", leaseID, ttl, err)
+	}
+	// restored dictionary is no more fresh.
+	if _, err := fresh.Restore(data); err != ErrorNotFresh {
+		t.Fatalf("expected %v, got %v", ErrorNotFresh, err)
+	}
+}
+
+func TestServerBackup(t *testing.T) {
+	sd, err := NewSafeDict(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &testRaftServer{term: 2}
+	s := &Server{db: sd, raftServer: rs, metrics: newMetrics()}
+	applied, _ := s.apply(&testContext{index: 5})
+	sd.Set("/a", 1, nullCAS)
+	applied()
+
+	var buf bytes.Buffer
+	var backup Backup
+	if err := s.Backup(&buf); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(buf.Bytes(), &backup); err != nil {
+		t.Fatal(err)
+	} else if backup.Term != 2 || backup.Index != 5 || backup.CAS != sd.GetCAS() {
+		t.Fatal("unexpected backup", backup.Term, backup.Index, backup.CAS)
+	}
+
+	// invalid backups are refused before replicating them.
+	backups := []string{
+		`backup`, `{"CAS": 2, "dict": "x"}`, `{"CAS": 3, "dict": {"m": {}, "CAS": 2}}`,
+	}
+	for _, data := range backups {
+		if _, err := s.Restore(strings.NewReader(data)); err != ErrorInvalidBackup {
+			t.Fatalf("expected %v for %s, got %v", ErrorInvalidBackup, data, err)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 // HTTP client API to access fail-safe dictionary - GetCAS(), Get(), Set(),
-// Delete().
+// Delete(), Patch(), Merge(), Txn(), Watch(), GetChangesSince(), GetAt(),
+// Compact(), GrantLease(), KeepAlive(), Revoke(), Members(), AddMember(),
+// RemoveMember().
 //
 // Example client {
 //      client := NewSafeDictClient(servAddr)
@@ -15,14 +17,25 @@
 // Above example will set the first user's eyeColor as brown and subsequently
 // delete the `eyeColor` field from user's property.
 //
+// Example transaction {
+//      ok, CAS, err := client.Txn().
+//          If(CompareMissing("/locks/rebalance")).
+//          Then(OpSet("/locks/rebalance", "node1")).
+//          Commit()
+// }
+//
+// NewClusterClient() accepts a list of seed servers, writes are routed to
+// the leader and requests fail over to other servers when a server is not
+// reachable.
+//
 // Get() and Set() allows full jsonpointer spec. to access SafeDict, while
 // Delete() allows does not allow the final element to be member of an array.
 //
 // Variants of Set and Delete calls
 //
-//                      SET         DELETE
-//  sync                 *            *
-//  sync with CAS        *            *
+//                      SET         DELETE      PATCH       MERGE
+//  sync                 *            *           *           *
+//  sync with CAS        *            *           *           *
 //
 // TODO: support full jsonpointer spec. for Delete().
 // TODO: figure out asynchronous operation for SET and DELETE.
@@ -30,32 +43,71 @@
 package failsafe
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // SafeDictClient instance
 type SafeDictClient struct {
-	serverAddr string
-	httpc      *http.Client
-	reqJSON    map[string]interface{} // reusable
-	respJSON   map[string]interface{} // reusable
+	cl       *cluster
+	reqJSON  map[string]interface{} // reusable
+	respJSON map[string]interface{} // reusable
+	// consistency level for reads, refer Consistency* constants.
+	consistency string
+	// field values by resource URL, revalidated using If-None-Match.
+	cache map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	etag  string // field's revision.
+	value interface{}
 }
 
 // NewSafeDictClient return reference to a new instance of SafeDictClient
+// for a single server, failed requests are not retried and all requests
+// are sent to serverAddr, which forwards them to the leader, unless
+// enabled by SetLeaderDiscovery. Use NewClusterClient() to fail over to
+// other servers.
 func NewSafeDictClient(serverAddr string) *SafeDictClient {
+	c := NewClusterClient([]string{serverAddr})
+	c.cl.retries, c.cl.discover = 0, false
+	return c
+}
+
+// NewClusterClient return reference to a new instance of SafeDictClient
+// for a cluster of servers, seeds are addresses of one or more servers in
+// the cluster. Writes, and reads with leader or linearizable consistency,
+// are routed to the leader, discovered from the seeds. Stale reads are
+// spread across servers. Requests fail over to other servers with
+// exponential backoff.
+func NewClusterClient(seeds []string) *SafeDictClient {
 	return &SafeDictClient{
-		serverAddr: serverAddr,
-		httpc:      http.DefaultClient,
-		reqJSON:    make(map[string]interface{}),
-		respJSON:   make(map[string]interface{}),
+		cl:       newCluster(seeds),
+		reqJSON:  make(map[string]interface{}),
+		respJSON: make(map[string]interface{}),
+		cache:    make(map[string]cachedResponse),
 	}
 }
 
+// SetConsistency level for subsequent reads, default is ConsistencyStale.
+func (c *SafeDictClient) SetConsistency(consistency string) {
+	c.consistency = consistency
+}
+
+// SetLeaderDiscovery to route writes, and reads with leader or
+// linearizable consistency, directly to the leader as advertised by
+// servers. Enabled by default for NewClusterClient. Shall be called before
+// using the client.
+func (c *SafeDictClient) SetLeaderDiscovery(discover bool) {
+	c.cl.discover = discover
+}
+
 // GetLeader for this cluster
 func (c *SafeDictClient) GetLeader() (leader string, leaderAddr string, err error) {
 	htresp, err := c.doHTTP(nil, nil, "HEAD")
@@ -73,26 +125,90 @@ func (c *SafeDictClient) GetCAS() (CAS uint64, err error) {
 	if err != nil {
 		return uint64(nullCAS), err
 	}
-	cas, err := strconv.ParseFloat(htresp.Header.Get("ETag"), 64)
+	cas, err := parseETag(htresp.Header.Get("ETag"))
 	if err != nil {
 		return uint64(nullCAS), err
 	}
 	return uint64(cas), nil
 }
 
-// Get value of the field located by `path` jsonpointer.
+// Get value of the field located by `path` jsonpointer. Values are cached
+// and revalidated against field's revision, when the field can be
+// addressed by URL.
 func (c *SafeDictClient) Get(path string) (value interface{}, CAS uint64, err error) {
+	if uri, ok := resourceURI(path); ok {
+		return c.getResource(uri)
+	}
 	defer func() { c.clean() }()
 
 	c.reqJSON["path"] = path
 	if _, err := c.doHTTP(c.reqJSON, c.respJSON, "GET"); err != nil {
 		return nil, uint64(nullCAS), err
 	} else if errstr := c.respJSON["err"].(string); errstr != "" {
-		return nil, uint64(nullCAS), fmt.Errorf(errstr)
+		return nil, uint64(nullCAS), c.respError(errstr)
 	}
 	return c.respJSON["value"], uint64(c.respJSON["CAS"].(float64)), nil
 }
 
+// GetRev is same as Get, additionally returns the revision of the field.
+func (c *SafeDictClient) GetRev(path string) (value interface{}, CAS uint64, rev Revision, err error) {
+	defer func() { c.clean() }()
+
+	c.reqJSON["path"] = path
+	if _, err := c.doHTTP(c.reqJSON, c.respJSON, "GET"); err != nil {
+		return nil, uint64(nullCAS), rev, err
+	} else if errstr := c.respJSON["err"].(string); errstr != "" {
+		return nil, uint64(nullCAS), rev, c.respError(errstr)
+	}
+	if m, ok := c.respJSON["rev"].(map[string]interface{}); ok {
+		rev.Create, _ = m["create"].(float64)
+		rev.Modify, _ = m["modify"].(float64)
+	}
+	return c.respJSON["value"], uint64(c.respJSON["CAS"].(float64)), rev, nil
+}
+
+// GetAt value of the field located by `path` jsonpointer, as it was at CAS.
+func (c *SafeDictClient) GetAt(path string, CAS uint64) (value interface{}, err error) {
+	defer func() { c.clean() }()
+
+	c.reqJSON["path"] = path
+	body, err := json.Marshal(&c.reqJSON)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"rev": {strconv.FormatUint(CAS, 10)}}
+	if c.consistency != "" {
+		query.Set("consistency", c.consistency)
+	}
+	uri := "/dict?" + query.Encode()
+	_, err = c.doRequest(body, c.respJSON, "GET", uri, "application/json", nil)
+	if err != nil {
+		return nil, err
+	} else if errstr := c.respJSON["err"].(string); errstr != "" {
+		return nil, c.respError(errstr)
+	}
+	return c.respJSON["value"], nil
+}
+
+// Compact older versions of the dictionary upto CAS, GetAt() for CAS older
+// than compacted CAS shall fail.
+func (c *SafeDictClient) Compact(CAS uint64) (nextCAS uint64, err error) {
+	defer func() { c.clean() }()
+
+	c.reqJSON["CAS"] = CAS
+	body, err := json.Marshal(&c.reqJSON)
+	if err != nil {
+		return uint64(nullCAS), err
+	}
+	_, err = c.doRequest(body, c.respJSON, "POST", "/dict/compact", "application/json", nil)
+	if err != nil {
+		return uint64(nullCAS), err
+	} else if errstr := c.respJSON["err"].(string); errstr != "" {
+		return uint64(nullCAS), c.respError(errstr)
+	}
+	return uint64(c.respJSON["CAS"].(float64)), nil
+}
+
 // Set value of the field located by `path` jsonpointer.
 func (c *SafeDictClient) Set(path string, value interface{}) (nextCAS uint64, err error) {
 	defer func() { c.clean() }()
@@ -102,12 +218,14 @@ func (c *SafeDictClient) Set(path string, value interface{}) (nextCAS uint64, er
 	if _, err := c.doHTTP(c.reqJSON, c.respJSON, "PUT"); err != nil {
 		return uint64(nullCAS), err
 	} else if errstr := c.respJSON["err"].(string); errstr != "" {
-		return uint64(nullCAS), fmt.Errorf(errstr)
+		return uint64(nullCAS), c.respError(errstr)
 	}
 	return uint64(c.respJSON["CAS"].(float64)), nil
 }
 
 // SetCAS value of the field located by `path` jsonpointer, for matching CAS.
+// CAS matches if the field is not modified after CAS, hence CAS can either
+// be the dictionary's CAS or the field's modify revision.
 func (c *SafeDictClient) SetCAS(path string, value interface{}, CAS uint64) (nextCAS uint64, err error) {
 	defer func() { c.clean() }()
 
@@ -115,7 +233,7 @@ func (c *SafeDictClient) SetCAS(path string, value interface{}, CAS uint64) (nex
 	if _, err := c.doHTTP(c.reqJSON, c.respJSON, "PUT"); err != nil {
 		return uint64(nullCAS), err
 	} else if errstr := c.respJSON["err"].(string); errstr != "" {
-		return uint64(nullCAS), fmt.Errorf(errstr)
+		return uint64(nullCAS), c.respError(errstr)
 	}
 	return uint64(c.respJSON["CAS"].(float64)), nil
 }
@@ -128,12 +246,13 @@ func (c *SafeDictClient) Delete(path string) (nextCAS uint64, err error) {
 	if _, err := c.doHTTP(c.reqJSON, c.respJSON, "DELETE"); err != nil {
 		return uint64(nullCAS), err
 	} else if errstr := c.respJSON["err"].(string); errstr != "" {
-		return uint64(nullCAS), fmt.Errorf(errstr)
+		return uint64(nullCAS), c.respError(errstr)
 	}
 	return uint64(c.respJSON["CAS"].(float64)), nil
 }
 
-// DeleteCAS field located by `path` jsonpointer with matching CAS.
+// DeleteCAS field located by `path` jsonpointer with matching CAS. CAS
+// matches if the field is not modified after CAS.
 func (c *SafeDictClient) DeleteCAS(path string, CAS uint64) (nextCAS uint64, err error) {
 	defer func() { c.clean() }()
 
@@ -141,11 +260,230 @@ func (c *SafeDictClient) DeleteCAS(path string, CAS uint64) (nextCAS uint64, err
 	if _, err := c.doHTTP(c.reqJSON, c.respJSON, "DELETE"); err != nil {
 		return uint64(nullCAS), err
 	} else if errstr := c.respJSON["err"].(string); errstr != "" {
-		return uint64(nullCAS), fmt.Errorf(errstr)
+		return uint64(nullCAS), c.respError(errstr)
+	}
+	return uint64(c.respJSON["CAS"].(float64)), nil
+}
+
+// SetLease field located by `path` jsonpointer and attach it to lease,
+// the field is deleted when the lease expires or is revoked.
+func (c *SafeDictClient) SetLease(path string, value interface{}, leaseID int64) (nextCAS uint64, err error) {
+	return c.SetLeaseCAS(path, value, uint64(nullCAS), leaseID)
+}
+
+// SetLeaseCAS field located by `path` jsonpointer with matching CAS and
+// attach it to lease.
+func (c *SafeDictClient) SetLeaseCAS(path string, value interface{}, CAS uint64, leaseID int64) (nextCAS uint64, err error) {
+	defer func() { c.clean() }()
+
+	c.reqJSON["path"], c.reqJSON["value"], c.reqJSON["CAS"] = path, value, CAS
+	c.reqJSON["lease"] = leaseID
+	if _, err := c.doHTTP(c.reqJSON, c.respJSON, "PUT"); err != nil {
+		return uint64(nullCAS), err
+	} else if errstr := c.respJSON["err"].(string); errstr != "" {
+		return uint64(nullCAS), c.respError(errstr)
 	}
 	return uint64(c.respJSON["CAS"].(float64)), nil
 }
 
+// GrantLease with time-to-live, the lease must be kept alive by calling
+// KeepAlive() within ttl.
+func (c *SafeDictClient) GrantLease(ttl time.Duration) (leaseID int64, err error) {
+	defer func() { c.clean() }()
+
+	c.reqJSON["ttl"] = ttl.Seconds()
+	if err := c.doLease("/lease/grant"); err != nil {
+		return 0, err
+	}
+	return int64(c.respJSON["lease"].(float64)), nil
+}
+
+// KeepAlive lease for another time-to-live period, shall be sent to the
+// leader.
+func (c *SafeDictClient) KeepAlive(leaseID int64) (ttl time.Duration, err error) {
+	defer func() { c.clean() }()
+
+	c.reqJSON["lease"] = leaseID
+	if err := c.doLease("/lease/keepalive"); err != nil {
+		return 0, err
+	}
+	return time.Duration(c.respJSON["ttl"].(float64) * float64(time.Second)), nil
+}
+
+// Revoke lease, all fields attached to the lease are deleted.
+func (c *SafeDictClient) Revoke(leaseID int64) (nextCAS uint64, err error) {
+	defer func() { c.clean() }()
+
+	c.reqJSON["lease"] = leaseID
+	if err := c.doLease("/lease/revoke"); err != nil {
+		return uint64(nullCAS), err
+	}
+	return uint64(c.respJSON["CAS"].(float64)), nil
+}
+
+func (c *SafeDictClient) doLease(uri string) error {
+	body, err := json.Marshal(&c.reqJSON)
+	if err != nil {
+		return err
+	}
+	_, err = c.doRequest(body, c.respJSON, "POST", uri, "application/json", nil)
+	if err != nil {
+		return err
+	} else if errstr := c.respJSON["err"].(string); errstr != "" {
+		return c.respError(errstr)
+	}
+	return nil
+}
+
+// Patch apply RFC 6902 JSON Patch document, all operations are applied
+// atomically.
+func (c *SafeDictClient) Patch(ops []PatchOp) (nextCAS uint64, err error) {
+	return c.patch(ops, nil)
+}
+
+// PatchCAS apply RFC 6902 JSON Patch document with matching CAS, all
+// operations are applied atomically.
+func (c *SafeDictClient) PatchCAS(ops []PatchOp, CAS uint64) (nextCAS uint64, err error) {
+	hdrs := map[string]string{"If-Match": formatETag(float64(CAS))}
+	return c.patch(ops, hdrs)
+}
+
+func (c *SafeDictClient) patch(
+	ops []PatchOp, hdrs map[string]string) (nextCAS uint64, err error) {
+
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return uint64(nullCAS), err
+	}
+	return c.doPatch(body, "/dict", HttpMimeJSONPatch, hdrs)
+}
+
+// Merge apply RFC 7386 JSON Merge Patch on the field located by `path`
+// jsonpointer.
+func (c *SafeDictClient) Merge(path string, patch interface{}) (nextCAS uint64, err error) {
+	return c.merge(path, patch, nil)
+}
+
+// MergeCAS apply RFC 7386 JSON Merge Patch on the field located by `path`
+// jsonpointer, for matching CAS.
+func (c *SafeDictClient) MergeCAS(path string, patch interface{}, CAS uint64) (nextCAS uint64, err error) {
+	hdrs := map[string]string{"If-Match": formatETag(float64(CAS))}
+	return c.merge(path, patch, hdrs)
+}
+
+func (c *SafeDictClient) merge(
+	path string, patch interface{},
+	hdrs map[string]string) (nextCAS uint64, err error) {
+
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return uint64(nullCAS), err
+	}
+	uri := "/dict?" + url.Values{"path": {path}}.Encode()
+	return c.doPatch(body, uri, HttpMimeMergePatch, hdrs)
+}
+
+func (c *SafeDictClient) doPatch(
+	body []byte, uri, contentType string,
+	hdrs map[string]string) (nextCAS uint64, err error) {
+
+	defer func() { c.clean() }()
+
+	_, err = c.doRequest(body, c.respJSON, "PATCH", uri, contentType, hdrs)
+	if err != nil {
+		return uint64(nullCAS), err
+	} else if errstr := c.respJSON["err"].(string); errstr != "" {
+		return uint64(nullCAS), c.respError(errstr)
+	}
+	return uint64(c.respJSON["CAS"].(float64)), nil
+}
+
+// TxnRequest is a transaction built by the client and committed on the
+// server.
+type TxnRequest struct {
+	client *SafeDictClient
+	txn    Txn
+}
+
+// Txn returns a new transaction for this client.
+func (c *SafeDictClient) Txn() *TxnRequest {
+	return &TxnRequest{client: c}
+}
+
+// If adds compare conditions to the transaction.
+func (t *TxnRequest) If(cmps ...TxnCompare) *TxnRequest {
+	t.txn.Compare = append(t.txn.Compare, cmps...)
+	return t
+}
+
+// Then adds operations to apply when all compare conditions succeed.
+func (t *TxnRequest) Then(ops ...TxnOp) *TxnRequest {
+	t.txn.Then = append(t.txn.Then, ops...)
+	return t
+}
+
+// Else adds operations to apply when any of the compare conditions fail.
+func (t *TxnRequest) Else(ops ...TxnOp) *TxnRequest {
+	t.txn.Else = append(t.txn.Else, ops...)
+	return t
+}
+
+// Commit the transaction on the server.
+func (t *TxnRequest) Commit() (succeeded bool, nextCAS uint64, err error) {
+	c := t.client
+	defer func() { c.clean() }()
+
+	body, err := json.Marshal(&t.txn)
+	if err != nil {
+		return false, uint64(nullCAS), err
+	}
+	_, err = c.doRequest(body, c.respJSON, "POST", "/dict/txn", "application/json", nil)
+	if err != nil {
+		return false, uint64(nullCAS), err
+	} else if errstr := c.respJSON["err"].(string); errstr != "" {
+		return false, uint64(nullCAS), c.respError(errstr)
+	}
+	succeeded = c.respJSON["succeeded"].(bool)
+	return succeeded, uint64(c.respJSON["CAS"].(float64)), nil
+}
+
+// GetChangesSince return every change to the dictionary after CAS, provided
+// server still remembers them.
+func (c *SafeDictClient) GetChangesSince(CAS uint64) ([]Change, error) {
+	return c.cl.getChangesSince(context.Background(), CAS)
+}
+
+// Watch for changes to fields under jsonpointer prefix, that happened after
+// fromCAS. Changes are streamed from the server, if the connection is lost
+// watch is resumed from the last received CAS by replaying changes from
+// server's history. Returned channel is closed after cancel is called or if
+// watch cannot be resumed, because server no longer remembers the changes.
+func (c *SafeDictClient) Watch(prefix string, fromCAS uint64) (ch <-chan Change, cancel func()) {
+	changes := make(chan Change, watchChanSize)
+	quit := make(chan struct{})
+	go c.cl.watch(prefix, fromCAS, changes, quit)
+
+	var once sync.Once
+	return changes, func() { once.Do(func() { close(quit) }) }
+}
+
+// Members of the cluster, as seen by the leader.
+func (c *SafeDictClient) Members() ([]Member, error) {
+	return c.cl.members(context.Background())
+}
+
+// AddMember to the cluster, server shall be started by joining the leader
+// with the same name and connection string.
+func (c *SafeDictClient) AddMember(name, connectionString string) error {
+	member := Member{Name: name, ConnectionString: connectionString}
+	return c.cl.changeMember(context.Background(), "POST", member)
+}
+
+// RemoveMember from the cluster.
+func (c *SafeDictClient) RemoveMember(name string) error {
+	return c.cl.changeMember(context.Background(), "DELETE", Member{Name: name})
+}
+
 // doHTTP post a request to server and get back a response for client APIs.
 func (c *SafeDictClient) doHTTP(
 	reqJSON, respJSON map[string]interface{},
@@ -159,42 +497,131 @@ func (c *SafeDictClient) doHTTP(
 			return nil, err
 		}
 	}
-	// make request
-	bodybuf := bytes.NewBuffer(body)
-	url := c.serverAddr + "/dict"
-	req, err := http.NewRequest(method, url, bodybuf)
-	if err != nil {
-		return nil, err
+	uri := "/dict"
+	if method == "GET" && c.consistency != "" {
+		uri += "?consistency=" + url.QueryEscape(c.consistency)
+	}
+
+	hdrs := make(map[string]string)
+	if method == "PUT" || method == "DELETE" {
+		if CAS, ok := reqJSON["CAS"].(uint64); ok && CAS != uint64(nullCAS) {
+			hdrs["If-Match"] = formatETag(float64(CAS))
+		}
 	}
-	req.Header.Add("Content-Type", "application/json")
-	// access server
-	htresp, err := c.httpc.Do(req)
+	return c.doRequest(body, respJSON, method, uri, "application/json", hdrs)
+}
+
+// getResource value of the field at resource uri, along with dictionary's
+// CAS. Cached value is returned if field is not modified since.
+func (c *SafeDictClient) getResource(uri string) (value interface{}, CAS uint64, err error) {
+	if c.consistency != "" {
+		uri += "?consistency=" + url.QueryEscape(c.consistency)
+	}
+	hdrs := make(map[string]string)
+	cached, ok := c.cache[uri]
+	if ok {
+		hdrs["If-None-Match"] = cached.etag
+	}
+	leader := toLeader("GET", c.consistency)
+	htresp, data, err := c.cl.do(
+		context.Background(), leader, "GET", uri, "application/json", nil, hdrs)
 	if err != nil {
-		return nil, err
+		return nil, uint64(nullCAS), err
+	}
+	CAS, _ = strconv.ParseUint(htresp.Header.Get(HttpHdrNameCAS), 10, 64)
+
+	switch htresp.StatusCode {
+	case http.StatusNotModified:
+		return copyJSON(cached.value), CAS, nil
+
+	case http.StatusOK:
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, uint64(nullCAS), err
+		}
+		if len(c.cache) >= clientCacheSize {
+			c.cache = make(map[string]cachedResponse)
+		}
+		etag := htresp.Header.Get("ETag")
+		c.cache[uri] = cachedResponse{etag: etag, value: copyJSON(value)}
+		return value, CAS, nil
 	}
-	// process response
-	defer htresp.Body.Close()
-	body, err = ioutil.ReadAll(htresp.Body)
+
+	delete(c.cache, uri)
+	var resp struct {
+		Err  string `json:"err"`
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, uint64(nullCAS), err
+	}
+	return nil, uint64(nullCAS), codeError(resp.Code, resp.Err)
+}
+
+// resourceURI return the URL addressing field at jsonpointer path, ok is
+// false if path cannot be addressed by URL, like top-level fields named
+// after dictRoutes.
+func resourceURI(path string) (uri string, ok bool) {
+	if path == "" {
+		return "/dict/", true
+	} else if path[0] != '/' {
+		return "", false
+	}
+	parts := strings.Split(path[1:], "/")
+	if len(parts) == 1 && hasString(dictRoutes, parts[0]) {
+		return "", false
+	}
+	for i, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return "", false
+		}
+		parts[i] = url.PathEscape(part)
+	}
+	return "/dict/" + strings.Join(parts, "/"), true
+}
+
+// doRequest post a request body of contentType, along with additional
+// headers, to server's uri and get back a response.
+func (c *SafeDictClient) doRequest(
+	body []byte, respJSON map[string]interface{},
+	method, uri, contentType string,
+	hdrs map[string]string) (resp *http.Response, err error) {
+
+	leader := toLeader(method, c.consistency)
+	htresp, data, err := c.cl.do(
+		context.Background(), leader, method, uri, contentType, body, hdrs)
 	if err != nil {
 		return nil, err
 	}
 	// unmarshal response
-	if respJSON != nil {
-		if err := json.Unmarshal(body, &respJSON); err != nil {
+	if respJSON != nil && htresp.StatusCode != http.StatusNotModified {
+		if err := json.Unmarshal(data, &respJSON); err != nil {
 			return nil, err
 		}
 	}
 	return htresp, nil
 }
 
+// respError rehydrates the error reported by server in response.
+func (c *SafeDictClient) respError(errstr string) error {
+	code, _ := c.respJSON["code"].(string)
+	return codeError(code, errstr)
+}
+
 // clean and reuse the structure for next request/response.
 func (c *SafeDictClient) clean() {
 	// clean request
 	delete(c.reqJSON, "path")
 	delete(c.reqJSON, "value")
 	delete(c.reqJSON, "CAS")
+	delete(c.reqJSON, "lease")
+	delete(c.reqJSON, "ttl")
 	// clean response
 	delete(c.respJSON, "value")
 	delete(c.respJSON, "CAS")
 	delete(c.respJSON, "err")
+	delete(c.respJSON, "code")
+	delete(c.respJSON, "succeeded")
+	delete(c.respJSON, "rev")
+	delete(c.respJSON, "lease")
+	delete(c.respJSON, "ttl")
 }
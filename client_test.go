@@ -1,10 +1,16 @@
 package failsafe
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/goraft/raft"
 )
 
 var _ = fmt.Sprintf("dummy") // TODO: remove this later.
@@ -98,6 +104,115 @@ func TestClientDelete(t *testing.T) {
 	}
 }
 
+func TestClientPatch(t *testing.T) {
+	if _, _, _, err := startTestServer(testRaftdir); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewSafeDictClient(servAddr)
+	CAS, _ := populate(client, smallJSON, t)
+	ops := []PatchOp{
+		{Op: "test", Path: "/eyeColor", Value: "brown"},
+		{Op: "replace", Path: "/eyeColor", Value: "weird"},
+		{Op: "copy", From: "/eyeColor", Path: "/hairColor"},
+	}
+	nextCAS, err := client.PatchCAS(ops, CAS)
+	if err != nil {
+		t.Fatal(err)
+	} else if nextCAS != CAS+1 {
+		t.Fatal("failed expected CAS as", CAS+1, nextCAS)
+	}
+	if value, _, err := client.Get("/hairColor"); err != nil {
+		t.Fatal(err)
+	} else if value.(string) != "weird" {
+		t.Fatal("failed", value.(string))
+	}
+	if _, err := client.Patch(ops); err != ErrorPatchTest {
+		t.Fatal("failed expected ErrorPatchTest", err)
+	}
+}
+
+func TestClientMerge(t *testing.T) {
+	if _, _, _, err := startTestServer(testRaftdir); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewSafeDictClient(servAddr)
+	CAS, _ := populate(client, smallJSON, t)
+	patch := map[string]interface{}{"first": "John", "last": nil}
+	nextCAS, err := client.MergeCAS("/name", patch, CAS)
+	if err != nil {
+		t.Fatal(err)
+	} else if nextCAS != CAS+1 {
+		t.Fatal("failed expected CAS as", CAS+1, nextCAS)
+	}
+	if value, _, err := client.Get("/name/first"); err != nil {
+		t.Fatal(err)
+	} else if value.(string) != "John" {
+		t.Fatal("failed", value.(string))
+	}
+	if _, _, err := client.Get("/name/last"); err == nil {
+		t.Fatal("failed expected ErrorInvalidPath")
+	}
+}
+
+func TestClientTxn(t *testing.T) {
+	if _, _, _, err := startTestServer(testRaftdir); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewSafeDictClient(servAddr)
+	CAS, _ := populate(client, smallJSON, t)
+	ok, nextCAS, err := client.Txn().
+		If(CompareValue("/isActive", true), CompareCAS(float64(CAS))).
+		Then(OpSet("/isActive", false), OpDelete("/eyeColor")).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("failed expected transaction to succeed")
+	} else if nextCAS != CAS+1 {
+		t.Fatal("failed expected CAS as", CAS+1, nextCAS)
+	}
+	if value, _, err := client.Get("/isActive"); err != nil {
+		t.Fatal(err)
+	} else if value.(bool) != false {
+		t.Fatal("failed", value)
+	}
+}
+
+func TestClientWatch(t *testing.T) {
+	if _, _, _, err := startTestServer(testRaftdir); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewSafeDictClient(servAddr)
+	CAS, _ := populate(client, smallJSON, t)
+	ch, cancel := client.Watch("/eyeColor", CAS)
+	defer cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	client.Set("/balance", "$0")
+	nextCAS, err := client.Set("/eyeColor", "green")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case change := <-ch:
+		if uint64(change.CAS) != nextCAS || change.Path != "/eyeColor" {
+			t.Fatal("failed unexpected change", change)
+		} else if change.NewValue.(string) != "green" {
+			t.Fatal("failed unexpected value", change.NewValue)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed expected change")
+	}
+}
+
 func BenchmarkClientGetCAS(b *testing.B) {
 	if _, _, _, err := startTestServer(testRaftdir); err != nil {
 		b.Fatal(err)
@@ -204,6 +319,361 @@ func BenchmarkClientDeleteCAS(b *testing.B) {
 	}
 }
 
+func TestClientConsistency(t *testing.T) {
+	if _, _, _, err := startTestServer(testRaftdir); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewSafeDictClient(servAddr)
+	populate(client, smallJSON, t)
+	for _, consistency := range []string{ConsistencyStale, ConsistencyLeader, ConsistencyLinearizable} {
+		client.SetConsistency(consistency)
+		if value, _, err := client.Get("/eyeColor"); err != nil {
+			t.Fatal(consistency, err)
+		} else if value != "brown" {
+			t.Fatal(consistency, "unexpected value", value)
+		}
+	}
+	client.SetConsistency("eventual")
+	if _, _, err := client.Get("/eyeColor"); err == nil {
+		t.Fatal("expected error for unknown consistency")
+	}
+}
+
+func TestClusterClient(t *testing.T) {
+	var leaderAddr string
+	var writes int
+	leader := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set(HttpHdrNameLeaderAddr, leaderAddr)
+			switch req.Method {
+			case "PUT":
+				writes++
+				w.Write([]byte(`{"CAS": 2, "err": ""}`))
+			case "GET":
+				w.Header().Set(HttpHdrNameCAS, "2")
+				w.Write([]byte(`10`))
+			}
+		}))
+	defer leader.Close()
+	leaderAddr = leader.URL
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	client := NewClusterClient([]string{dead.URL, leader.URL})
+	if CAS, err := client.Set("/path", 10); err != nil {
+		t.Fatal(err)
+	} else if CAS != 2 {
+		t.Fatal("unexpected CAS", CAS)
+	} else if writes != 1 {
+		t.Fatal("expected write on leader", writes)
+	}
+	// stale reads fail over from the dead server.
+	for i := 0; i < 2; i++ {
+		if value, _, err := client.Get("/path"); err != nil {
+			t.Fatal(err)
+		} else if value != float64(10) {
+			t.Fatal("unexpected value", value)
+		}
+	}
+}
+
+func TestClusterClientRetry(t *testing.T) {
+	var leaderAddr string
+	var puts, gets, stalePuts int
+	hangup := func(w http.ResponseWriter) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}
+	leader := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set(HttpHdrNameLeaderAddr, leaderAddr)
+			switch req.Method {
+			case "PUT":
+				if puts++; puts == 1 {
+					hangup(w) // write reached the server.
+					return
+				}
+				w.Write([]byte(`{"CAS": 2, "err": ""}`))
+			case "GET":
+				if gets++; gets == 1 {
+					hangup(w)
+					return
+				}
+				w.Header().Set(HttpHdrNameCAS, "2")
+				w.Write([]byte(`10`))
+			}
+		}))
+	defer leader.Close()
+	leaderAddr = leader.URL
+
+	// stale leader refuses writes, that are retried on the new leader.
+	var stale *httptest.Server
+	stale = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == "HEAD" && stalePuts == 0 {
+				w.Header().Set(HttpHdrNameLeaderAddr, stale.URL)
+				return
+			}
+			w.Header().Set(HttpHdrNameLeaderAddr, leaderAddr)
+			if req.Method == "PUT" {
+				stalePuts++
+				w.Write([]byte(`{"err": "not leader", "code": "not-leader"}`))
+			}
+		}))
+	defer stale.Close()
+
+	client := NewClusterClient([]string{stale.URL})
+	if _, err := client.Set("/path", 10); err == nil {
+		t.Fatal("expected write to fail without retry")
+	} else if stalePuts != 1 || puts != 1 {
+		t.Fatal("unexpected writes", stalePuts, puts)
+	}
+	if CAS, err := client.Set("/path", 10); err != nil {
+		t.Fatal(err)
+	} else if CAS != 2 || puts != 2 {
+		t.Fatal("unexpected write", CAS, puts)
+	}
+	// reads are retried after reaching the server.
+	client = NewClusterClient([]string{leader.URL})
+	if value, _, err := client.Get("/path"); err != nil {
+		t.Fatal(err)
+	} else if value != float64(10) || gets != 2 {
+		t.Fatal("unexpected read", value, gets)
+	}
+}
+
+func TestSafeDictClientDead(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	client := NewSafeDictClient(dead.URL)
+	start := time.Now()
+	if _, _, err := client.Get("/path"); err == nil {
+		t.Fatal("expected error from dead server")
+	} else if _, err := client.Set("/path", 10); err == nil {
+		t.Fatal("expected error from dead server")
+	} else if elapsed := time.Since(start); elapsed > clientBackoff {
+		t.Fatal("expected requests to fail without backoff", elapsed)
+	}
+}
+
+func TestSafeDictClientAddress(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	// leader advertises an address that the client cannot reach.
+	var writes int
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set(HttpHdrNameLeaderAddr, dead.URL)
+			if req.Method == "PUT" {
+				writes++
+				w.Write([]byte(`{"CAS": 2, "err": ""}`))
+			}
+		}))
+	defer server.Close()
+
+	client := NewSafeDictClient(server.URL)
+	if CAS, err := client.Set("/path", 10); err != nil {
+		t.Fatal(err)
+	} else if CAS != 2 || writes != 1 {
+		t.Fatal("expected write on configured server", CAS, writes)
+	}
+	client.SetLeaderDiscovery(true)
+	if _, err := client.Set("/path", 10); err == nil {
+		t.Fatal("expected write to fail on advertised leader")
+	} else if writes != 1 {
+		t.Fatal("unexpected write on configured server", writes)
+	}
+}
+
+func TestClientWatchResume(t *testing.T) {
+	sd, _ := NewSafeDict(nil, true)
+	sd.SetHistorySize(defaultHistorySize)
+	sd.Set("/a", "x", nullCAS)
+	txn := &Txn{Then: []TxnOp{OpSet("/b", "y"), OpSet("/c", "z")}}
+	sd.Txn(txn)
+	s := &Server{db: sd}
+
+	// first connection is lost after a partially received write.
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			queries = append(queries, req.URL.Query().Get("CAS"))
+			if len(queries) > 1 {
+				s.watchHandler(w, req)
+				return
+			}
+			enc := json.NewEncoder(w)
+			changes, _ := sd.GetChangesSince(1)
+			enc.Encode(changes[0])
+			enc.Encode(changes[1])
+		}))
+	defer server.Close()
+
+	client := NewClusterClient([]string{server.URL})
+	ch, cancel := client.Watch("", 1)
+	defer cancel()
+	paths := []string{}
+	for len(paths) < 3 {
+		select {
+		case change := <-ch:
+			paths = append(paths, change.Path)
+		case <-time.After(time.Second):
+			t.Fatal("failed expected change", paths)
+		}
+	}
+	if reflect.DeepEqual(paths, []string{"/a", "/b", "/c"}) == false {
+		t.Fatal("unexpected changes", paths)
+	} else if queries[1] != "2" {
+		t.Fatal("expected watch to resume from previous CAS", queries)
+	}
+
+	// watch cannot be resumed once changes are compacted.
+	sd.SetHistorySize(0)
+	ch, cancel = client.Watch("", 1)
+	defer cancel()
+	select {
+	case change, ok := <-ch:
+		if ok {
+			t.Fatal("unexpected change", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected watch to be closed")
+	}
+}
+
+func TestClientWatchResumeFromNow(t *testing.T) {
+	sd, _ := NewSafeDict(nil, true)
+	sd.SetHistorySize(defaultHistorySize)
+	sd.Set("/a", "x", nullCAS)
+	s := &Server{db: sd}
+
+	// first connection is lost before receiving any change, and a write
+	// is committed while reconnecting.
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			queries = append(queries, req.URL.Query().Get("CAS"))
+			if len(queries) > 1 {
+				s.watchHandler(w, req)
+				return
+			}
+			w.Header().Set(HttpHdrNameCAS, fmt.Sprintf("%v", sd.GetCAS()))
+			w.WriteHeader(http.StatusOK)
+			sd.Set("/b", "y", nullCAS)
+		}))
+	defer server.Close()
+
+	client := NewClusterClient([]string{server.URL})
+	ch, cancel := client.Watch("", 0)
+	defer cancel()
+	select {
+	case change := <-ch:
+		if change.Path != "/b" {
+			t.Fatal("unexpected change", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed expected change", queries)
+	}
+	if queries[0] != "0" || queries[1] != "2" {
+		t.Fatal("expected watch to resume from first stream's CAS", queries)
+	}
+}
+
+func TestClientConditional(t *testing.T) {
+	var gets, notModified int
+	var ifMatch, leaderAddr string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set(HttpHdrNameLeaderAddr, leaderAddr)
+			switch req.Method {
+			case "GET":
+				if req.URL.Path != "/dict/path" {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"err": "safedict.errorInvalidPath", "code": "invalid-path"}`))
+					return
+				}
+				gets++
+				w.Header().Set("ETag", `"2"`)
+				w.Header().Set(HttpHdrNameCAS, strconv.Itoa(4+gets))
+				if req.Header.Get("If-None-Match") == `"2"` {
+					notModified++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Write([]byte(`[1, 2]`))
+			case "PUT":
+				ifMatch = req.Header.Get("If-Match")
+				w.Write([]byte(`{"CAS": 3, "err": "", "code": ""}`))
+			}
+		}))
+	defer server.Close()
+	leaderAddr = server.URL
+
+	client := NewSafeDictClient(server.URL)
+	for i := 0; i < 3; i++ {
+		value, CAS, err := client.Get("/path")
+		if err != nil {
+			t.Fatal(err)
+		} else if CAS != uint64(5+i) {
+			t.Fatal("unexpected CAS", CAS)
+		} else if reflect.DeepEqual(value, []interface{}{float64(1), float64(2)}) == false {
+			t.Fatal("unexpected value", value)
+		}
+		value.([]interface{})[0] = "mutated" // shall not affect the cache.
+	}
+	if gets != 3 || notModified != 2 {
+		t.Fatal("expected revalidation", gets, notModified)
+	}
+
+	if _, err := client.SetCAS("/path", 10, 2); err != nil {
+		t.Fatal(err)
+	} else if ifMatch != `"2"` {
+		t.Fatal("expected If-Match header", ifMatch)
+	}
+	if _, _, err := client.Get("/missing"); err != ErrorInvalidPath {
+		t.Fatal("expected ErrorInvalidPath", err)
+	}
+}
+
+func TestClientGetReserved(t *testing.T) {
+	sd, _ := NewSafeDict([]byte(`{"watch": 1, "txn": {"a": 2}}`), true)
+	rs := &testRaftServer{name: "n1", leader: "n1", state: raft.Leader}
+	mux := http.NewServeMux()
+	s := &Server{
+		db: sd, raftServer: rs, mux: mux, stats: NewStats(), metrics: newMetrics(),
+	}
+	s.installHandlers()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer server.CloseClientConnections() // in case a read hangs.
+
+	client := NewSafeDictClient(server.URL)
+	donech := make(chan struct{})
+	go func() {
+		defer close(donech)
+		if value, _, err := client.Get("/watch"); err != nil {
+			t.Error(err)
+		} else if value != float64(1) {
+			t.Error("unexpected value", value)
+		}
+		if value, _, err := client.Get("/txn/a"); err != nil {
+			t.Error(err)
+		} else if value != float64(2) {
+			t.Error("unexpected value", value)
+		}
+	}()
+	select {
+	case <-donech:
+	case <-time.After(time.Second):
+		t.Fatal("expected reads to complete")
+	}
+}
+
 func populate(client *SafeDictClient, data []byte, tb testing.TB) (uint64, *SafeDict) {
 	sd, err := NewSafeDict(smallJSON, true)
 	if err != nil {
@@ -232,3 +702,58 @@ func addKeys(client *SafeDictClient, N int) []string {
 	}
 	return ks
 }
+
+func TestClientMembers(t *testing.T) {
+	var leaderAddr string
+	members := []Member{{Name: "n1", ConnectionString: "http://n1", Role: RoleLeader}}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set(HttpHdrNameLeaderAddr, leaderAddr)
+			if req.Method == "HEAD" {
+				return
+			}
+			var member Member
+			json.NewDecoder(req.Body).Decode(&member)
+			var err error
+			switch req.Method {
+			case "POST":
+				member.Role = RoleFollower
+				members = append(members, member)
+			case "DELETE":
+				err = ErrorMemberNotFound
+				for i, m := range members {
+					if m.Name == member.Name {
+						members, err = append(members[:i], members[i+1:]...), nil
+						break
+					}
+				}
+			}
+			m := map[string]interface{}{
+				"members": members, "err": errorString(err), "code": errorCode(err),
+			}
+			writeJSON(w, req, m)
+		}))
+	defer server.Close()
+	leaderAddr = server.URL
+
+	client := NewSafeDictClient(server.URL)
+	if err := client.AddMember("n2", "http://n2"); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := client.Members()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ms, members) {
+		t.Fatalf("expected %v, got %v", members, ms)
+	}
+	if err := client.RemoveMember("n2"); err != nil {
+		t.Fatal(err)
+	} else if err := client.RemoveMember("n2"); err != ErrorMemberNotFound {
+		t.Fatalf("expected %v, got %v", ErrorMemberNotFound, err)
+	}
+	if ms, err := client.Members(); err != nil {
+		t.Fatal(err)
+	} else if len(ms) != 1 || ms[0].Name != "n1" {
+		t.Fatal("unexpected members", ms)
+	}
+}
@@ -0,0 +1,344 @@
+// Context aware HTTP client API to access fail-safe dictionary.
+//
+// Client is safe for concurrent use, every method accepts a context for
+// deadlines and cancellation, and failed requests return *ClientError
+// that wraps the error reported by the server, hence errors.Is can be used
+// to compare with SafeDict errors like ErrorInvalidCAS.
+//
+// Example client {
+//      client := NewClient(servAddr1, servAddr2)
+//      CAS, err := client.GetCAS(ctx)
+//      CAS, err = client.SetCAS(ctx, "/users/0/eyeColor", "brown", CAS)
+//      if errors.Is(err, ErrorInvalidCAS) {
+//          // retry
+//      }
+// }
+
+package failsafe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientError is returned by Client when a request fails. Err is the
+// error reported by server, rehydrated from its error code, or the error
+// encountered while talking to server.
+type ClientError struct {
+	Method string
+	URI    string
+	Status int // HTTP status code, zero if there was no response.
+	Err    error
+}
+
+func (err *ClientError) Error() string {
+	return fmt.Sprintf("failsafe: %v %v: %v", err.Method, err.URI, err.Err)
+}
+
+// Unwrap return the underlying error.
+func (err *ClientError) Unwrap() error {
+	return err.Err
+}
+
+// Client instance, safe for concurrent use.
+type Client struct {
+	cl          *cluster
+	consistency string
+}
+
+// NewClient return reference to a new instance of Client for a cluster of
+// servers, seeds are addresses of one or more servers in the cluster.
+func NewClient(seeds ...string) *Client {
+	return &Client{cl: newCluster(seeds)}
+}
+
+// WithConsistency return a client, sharing the same cluster, whose reads
+// are served at specified consistency level.
+func (c *Client) WithConsistency(consistency string) *Client {
+	return &Client{cl: c.cl, consistency: consistency}
+}
+
+// GetLeader for this cluster.
+func (c *Client) GetLeader(ctx context.Context) (leader, leaderAddr string, err error) {
+	htresp, err := c.call(ctx, "HEAD", "/dict", "", nil, nil, nil)
+	if err != nil {
+		return "", "", err
+	}
+	leader = htresp.Header.Get(HttpHdrNameLeader)
+	leaderAddr = htresp.Header.Get(HttpHdrNameLeaderAddr)
+	return leader, leaderAddr, nil
+}
+
+// GetCAS from fail-safe dictionary.
+func (c *Client) GetCAS(ctx context.Context) (CAS uint64, err error) {
+	htresp, err := c.call(ctx, "HEAD", "/dict", "", nil, nil, nil)
+	if err != nil {
+		return uint64(nullCAS), err
+	}
+	cas, err := parseETag(htresp.Header.Get("ETag"))
+	if err != nil {
+		return uint64(nullCAS), err
+	}
+	return uint64(cas), nil
+}
+
+// Get value of the field located by `path` jsonpointer.
+func (c *Client) Get(ctx context.Context, path string) (value interface{}, CAS uint64, err error) {
+	value, CAS, _, err = c.GetRev(ctx, path)
+	return value, CAS, err
+}
+
+// GetRev is same as Get, additionally returns the revision of the field.
+func (c *Client) GetRev(ctx context.Context, path string) (value interface{}, CAS uint64, rev Revision, err error) {
+	var resp struct {
+		Value interface{} `json:"value"`
+		CAS   float64     `json:"CAS"`
+		Rev   Revision    `json:"rev"`
+	}
+	req := map[string]interface{}{"path": path}
+	uri := c.readURI(url.Values{})
+	if _, err := c.call(ctx, "GET", uri, "", nil, req, &resp); err != nil {
+		return nil, uint64(nullCAS), rev, err
+	}
+	return resp.Value, uint64(resp.CAS), resp.Rev, nil
+}
+
+// GetAt value of the field located by `path` jsonpointer, as it was at CAS.
+func (c *Client) GetAt(ctx context.Context, path string, CAS uint64) (value interface{}, err error) {
+	var resp struct {
+		Value interface{} `json:"value"`
+	}
+	req := map[string]interface{}{"path": path}
+	uri := c.readURI(url.Values{"rev": {strconv.FormatUint(CAS, 10)}})
+	if _, err := c.call(ctx, "GET", uri, "", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// Set value of the field located by `path` jsonpointer.
+func (c *Client) Set(ctx context.Context, path string, value interface{}) (nextCAS uint64, err error) {
+	return c.SetCAS(ctx, path, value, uint64(nullCAS))
+}
+
+// SetCAS value of the field located by `path` jsonpointer, for matching
+// CAS.
+func (c *Client) SetCAS(ctx context.Context, path string, value interface{}, CAS uint64) (nextCAS uint64, err error) {
+	req := map[string]interface{}{"path": path, "value": value, "CAS": CAS}
+	return c.write(ctx, "PUT", "/dict", "", ifMatch(CAS), req)
+}
+
+// SetLease value of the field located by `path` jsonpointer, for matching
+// CAS, and attach it to lease.
+func (c *Client) SetLease(ctx context.Context, path string, value interface{}, CAS uint64, leaseID int64) (nextCAS uint64, err error) {
+	req := map[string]interface{}{
+		"path": path, "value": value, "CAS": CAS, "lease": leaseID,
+	}
+	return c.write(ctx, "PUT", "/dict", "", nil, req)
+}
+
+// Delete field located by `path` jsonpointer.
+func (c *Client) Delete(ctx context.Context, path string) (nextCAS uint64, err error) {
+	return c.DeleteCAS(ctx, path, uint64(nullCAS))
+}
+
+// DeleteCAS field located by `path` jsonpointer with matching CAS.
+func (c *Client) DeleteCAS(ctx context.Context, path string, CAS uint64) (nextCAS uint64, err error) {
+	req := map[string]interface{}{"path": path, "CAS": CAS}
+	return c.write(ctx, "DELETE", "/dict", "", ifMatch(CAS), req)
+}
+
+// Patch apply RFC 6902 JSON Patch document with matching CAS, CAS is
+// ignored if it is zero.
+func (c *Client) Patch(ctx context.Context, ops []PatchOp, CAS uint64) (nextCAS uint64, err error) {
+	return c.write(ctx, "PATCH", "/dict", HttpMimeJSONPatch, ifMatch(CAS), ops)
+}
+
+// Merge apply RFC 7386 JSON Merge Patch on the field located by `path`
+// jsonpointer with matching CAS, CAS is ignored if it is zero.
+func (c *Client) Merge(ctx context.Context, path string, patch interface{}, CAS uint64) (nextCAS uint64, err error) {
+	uri := "/dict?" + url.Values{"path": {path}}.Encode()
+	return c.write(ctx, "PATCH", uri, HttpMimeMergePatch, ifMatch(CAS), patch)
+}
+
+// Txn commit the transaction on the server.
+func (c *Client) Txn(ctx context.Context, txn *Txn) (succeeded bool, nextCAS uint64, err error) {
+	var resp struct {
+		Succeeded bool    `json:"succeeded"`
+		CAS       float64 `json:"CAS"`
+	}
+	if _, err := c.call(ctx, "POST", "/dict/txn", "", nil, txn, &resp); err != nil {
+		return false, uint64(nullCAS), err
+	}
+	return resp.Succeeded, uint64(resp.CAS), nil
+}
+
+// Compact older versions of the dictionary upto CAS.
+func (c *Client) Compact(ctx context.Context, CAS uint64) (nextCAS uint64, err error) {
+	req := map[string]interface{}{"CAS": CAS}
+	return c.write(ctx, "POST", "/dict/compact", "", nil, req)
+}
+
+// GrantLease with time-to-live.
+func (c *Client) GrantLease(ctx context.Context, ttl time.Duration) (leaseID int64, err error) {
+	var resp struct {
+		Lease int64 `json:"lease"`
+	}
+	req := map[string]interface{}{"ttl": ttl.Seconds()}
+	if _, err := c.call(ctx, "POST", "/lease/grant", "", nil, req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Lease, nil
+}
+
+// KeepAlive lease for another time-to-live period.
+func (c *Client) KeepAlive(ctx context.Context, leaseID int64) (ttl time.Duration, err error) {
+	var resp struct {
+		TTL float64 `json:"ttl"`
+	}
+	req := map[string]interface{}{"lease": leaseID}
+	if _, err := c.call(ctx, "POST", "/lease/keepalive", "", nil, req, &resp); err != nil {
+		return 0, err
+	}
+	return time.Duration(resp.TTL * float64(time.Second)), nil
+}
+
+// Revoke lease, all fields attached to the lease are deleted.
+func (c *Client) Revoke(ctx context.Context, leaseID int64) (nextCAS uint64, err error) {
+	req := map[string]interface{}{"lease": leaseID}
+	return c.write(ctx, "POST", "/lease/revoke", "", nil, req)
+}
+
+// GetChangesSince return every change to the dictionary after CAS,
+// provided server still remembers them.
+func (c *Client) GetChangesSince(ctx context.Context, CAS uint64) ([]Change, error) {
+	changes, err := c.cl.getChangesSince(ctx, CAS)
+	if err != nil {
+		return nil, &ClientError{Method: "GET", URI: "/dict/changes", Err: err}
+	}
+	return changes, nil
+}
+
+// Watch for changes to fields under jsonpointer prefix, that happened
+// after fromCAS. Lost watch is resumed from server's history, returned
+// channel is closed when ctx is done or if watch cannot be resumed.
+func (c *Client) Watch(ctx context.Context, prefix string, fromCAS uint64) <-chan Change {
+	changes := make(chan Change, watchChanSize)
+	go c.cl.watch(prefix, fromCAS, changes, ctx.Done())
+	return changes
+}
+
+// Members of the cluster, as seen by the leader.
+func (c *Client) Members(ctx context.Context) ([]Member, error) {
+	members, err := c.cl.members(ctx)
+	if err != nil {
+		return nil, &ClientError{Method: "GET", URI: "/admin/members", Err: err}
+	}
+	return members, nil
+}
+
+// AddMember to the cluster, server shall be started by joining the leader
+// with the same name and connection string.
+func (c *Client) AddMember(ctx context.Context, name, connectionString string) error {
+	member := Member{Name: name, ConnectionString: connectionString}
+	if err := c.cl.changeMember(ctx, "POST", member); err != nil {
+		return &ClientError{Method: "POST", URI: "/admin/members", Err: err}
+	}
+	return nil
+}
+
+// RemoveMember from the cluster.
+func (c *Client) RemoveMember(ctx context.Context, name string) error {
+	if err := c.cl.changeMember(ctx, "DELETE", Member{Name: name}); err != nil {
+		return &ClientError{Method: "DELETE", URI: "/admin/members", Err: err}
+	}
+	return nil
+}
+
+// write request and return the next CAS.
+func (c *Client) write(
+	ctx context.Context, method, uri, contentType string,
+	hdrs map[string]string, req interface{}) (nextCAS uint64, err error) {
+
+	var resp struct {
+		CAS float64 `json:"CAS"`
+	}
+	if _, err := c.call(ctx, method, uri, contentType, hdrs, req, &resp); err != nil {
+		return uint64(nullCAS), err
+	}
+	return uint64(resp.CAS), nil
+}
+
+// call server with JSON encoded request and decode JSON response into
+// resp.
+func (c *Client) call(
+	ctx context.Context, method, uri, contentType string,
+	hdrs map[string]string, req, resp interface{}) (*http.Response, error) {
+
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return nil, err
+		}
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	leader := toLeader(method, c.consistency)
+	htresp, data, err := c.cl.do(ctx, leader, method, uri, contentType, body, hdrs)
+	if err != nil {
+		return nil, &ClientError{Method: method, URI: uri, Err: err}
+	} else if method == "HEAD" {
+		return htresp, nil
+	}
+
+	var envelope struct {
+		Err  string `json:"err"`
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		// not a JSON response, like those from http.Error().
+		msg := strings.TrimSpace(string(data))
+		return nil, &ClientError{
+			Method: method, URI: uri, Status: htresp.StatusCode,
+			Err: errors.New(msg),
+		}
+	} else if envelope.Err != "" {
+		return nil, &ClientError{
+			Method: method, URI: uri, Status: htresp.StatusCode,
+			Err: codeError(envelope.Code, envelope.Err),
+		}
+	}
+	if resp != nil {
+		if err := json.Unmarshal(data, resp); err != nil {
+			return nil, err
+		}
+	}
+	return htresp, nil
+}
+
+// readURI for /dict with query, adding the read consistency.
+func (c *Client) readURI(query url.Values) string {
+	if c.consistency != "" {
+		query.Set("consistency", c.consistency)
+	}
+	if len(query) == 0 {
+		return "/dict"
+	}
+	return "/dict?" + query.Encode()
+}
+
+func ifMatch(CAS uint64) map[string]string {
+	if CAS == uint64(nullCAS) {
+		return nil
+	}
+	return map[string]string{"If-Match": formatETag(float64(CAS))}
+}
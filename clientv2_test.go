@@ -0,0 +1,87 @@
+package failsafe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientV2(t *testing.T) {
+	var mu sync.Mutex
+	var CAS float64 = 1
+	var leaderAddr string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set(HttpHdrNameLeaderAddr, leaderAddr)
+			switch req.Method {
+			case "HEAD":
+				w.Header().Set("ETag", "1")
+			case "PUT":
+				jsonreq, _ := parseRequest(req)
+				mu.Lock()
+				defer mu.Unlock()
+				if cas := jsonreq["CAS"].(float64); cas != nullCAS && cas != CAS {
+					w.WriteHeader(http.StatusConflict)
+					w.Write([]byte(`{"CAS": 0, "err": "safedict.errorInvalidCAS", "code": "cas-mismatch"}`))
+					return
+				}
+				CAS++
+				w.Write([]byte(`{"CAS": ` + jsonString(CAS) + `, "err": ""}`))
+			case "GET":
+				time.Sleep(100 * time.Millisecond)
+				w.Write([]byte(`{"value": 10, "CAS": 1, "err": ""}`))
+			}
+		}))
+	defer server.Close()
+	leaderAddr = server.URL
+
+	client := NewClient(server.URL)
+	if CAS, err := client.GetCAS(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if CAS != 1 {
+		t.Fatal("unexpected CAS", CAS)
+	}
+
+	// concurrent writes.
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Set(context.Background(), "/path", 10); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if CAS != 101 {
+		t.Fatal("expected 100 writes", CAS)
+	}
+
+	// structured errors.
+	_, err := client.SetCAS(context.Background(), "/path", 10, 1)
+	if errors.Is(err, ErrorInvalidCAS) == false {
+		t.Fatal("expected ErrorInvalidCAS", err)
+	}
+	var clerr *ClientError
+	if errors.As(err, &clerr) == false || clerr.Status != http.StatusConflict {
+		t.Fatal("expected ClientError", err)
+	}
+
+	// deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := client.Get(ctx, "/path"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded", err)
+	}
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,373 @@
+// Cluster of servers as seen by clients.
+//
+// Clients know a list of servers, starting with the seeds, and the leader
+// once it is discovered. Writes, and reads with leader or linearizable
+// consistency, are routed to the leader, other reads are spread across
+// servers. Failed requests are retried on other servers with exponential
+// backoff, provided retrying cannot apply a write twice. cluster is safe for
+// concurrent use and is shared by SafeDictClient and Client.
+
+package failsafe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrorLeaderUnknown when none of the known servers could tell the leader.
+var ErrorLeaderUnknown = fmt.Errorf("failsafe.errorLeaderUnknown")
+
+type cluster struct {
+	httpc      *http.Client
+	retries    int  // number of times a failed request is retried.
+	discover   bool // route requests to the leader, discovered from servers.
+	mu         sync.Mutex
+	servers    []string // seeds and discovered servers.
+	leaderAddr string   // empty if not known.
+	next       int      // round robin reads across servers.
+}
+
+func newCluster(seeds []string) *cluster {
+	return &cluster{
+		httpc:    http.DefaultClient,
+		retries:  clientRetries,
+		discover: true,
+		servers:  append([]string(nil), seeds...),
+	}
+}
+
+// toLeader return true if request shall be routed to the leader.
+func toLeader(method, consistency string) bool {
+	if method != "GET" && method != "HEAD" {
+		return true
+	}
+	return consistency == ConsistencyLeader || consistency == ConsistencyLinearizable
+}
+
+// do post a request body of contentType, along with additional headers,
+// to server's uri and return the response and its body. Request is retried
+// on other servers with exponential backoff, if the server is not
+// reachable or is no more the leader. Writes that failed after reaching
+// the server are not retried, as they might have been applied. Without
+// leader discovery, requests for the leader are left to the servers to
+// forward.
+func (cl *cluster) do(
+	ctx context.Context, leader bool,
+	method, uri, contentType string, body []byte,
+	hdrs map[string]string) (resp *http.Response, data []byte, err error) {
+
+	leader = leader && cl.discover
+	backoff := clientBackoff
+	for retry := 0; ; retry++ {
+		var serverAddr string
+		if leader {
+			serverAddr, err = cl.discoverLeader(ctx)
+		} else {
+			serverAddr, err = cl.pickServer(), nil
+		}
+		if err == nil {
+			resp, data, err = cl.request(
+				ctx, serverAddr, method, uri, contentType, body, hdrs)
+			if err == nil && !(leader && isNotLeader(data)) {
+				return resp, data, nil
+			}
+			cl.failover(serverAddr)
+			if err != nil && !isIdempotent(method) && !isDialError(err) {
+				return nil, nil, err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		} else if retry >= cl.retries {
+			return resp, data, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > clientMaxBackoff {
+			backoff = clientMaxBackoff
+		}
+	}
+}
+
+func (cl *cluster) request(
+	ctx context.Context, serverAddr, method, uri, contentType string,
+	body []byte, hdrs map[string]string) (*http.Response, []byte, error) {
+
+	// make request
+	req, err := http.NewRequest(method, serverAddr+uri, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Add("Content-Type", contentType)
+	for name, value := range hdrs {
+		req.Header.Set(name, value)
+	}
+	// access server
+	htresp, err := cl.httpc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	// process response
+	defer htresp.Body.Close()
+	data, err := ioutil.ReadAll(htresp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return htresp, data, nil
+}
+
+// pickServer for next request, round robin across known servers.
+func (cl *cluster) pickServer() string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	serverAddr := cl.servers[cl.next%len(cl.servers)]
+	cl.next++
+	return serverAddr
+}
+
+// discoverLeader from known servers, using HEAD request. Leader is
+// remembered until it fails.
+func (cl *cluster) discoverLeader(ctx context.Context) (string, error) {
+	cl.mu.Lock()
+	leaderAddr, servers := cl.leaderAddr, append([]string(nil), cl.servers...)
+	cl.mu.Unlock()
+	if leaderAddr != "" {
+		return leaderAddr, nil
+	}
+
+	for _, serverAddr := range servers {
+		req, err := http.NewRequest("HEAD", serverAddr+"/dict", nil)
+		if err != nil {
+			continue
+		}
+		htresp, err := cl.httpc.Do(req.WithContext(ctx))
+		if err != nil {
+			continue
+		}
+		htresp.Body.Close()
+		if leaderAddr = htresp.Header.Get(HttpHdrNameLeaderAddr); leaderAddr == "" {
+			continue
+		}
+		cl.mu.Lock()
+		cl.leaderAddr = leaderAddr
+		if !hasString(cl.servers, leaderAddr) {
+			cl.servers = append(cl.servers, leaderAddr)
+		}
+		cl.mu.Unlock()
+		return leaderAddr, nil
+	}
+	return "", ErrorLeaderUnknown
+}
+
+// failover from server, if it is the leader it shall be discovered again.
+func (cl *cluster) failover(serverAddr string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.leaderAddr == serverAddr {
+		cl.leaderAddr = ""
+	}
+}
+
+// getChangesSince return every change to the dictionary after CAS,
+// provided server still remembers them.
+func (cl *cluster) getChangesSince(ctx context.Context, CAS uint64) ([]Change, error) {
+	query := url.Values{"CAS": {strconv.FormatUint(CAS, 10)}}
+	uri := "/dict/changes?" + query.Encode()
+	_, data, err := cl.do(ctx, false, "GET", uri, "application/json", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := struct {
+		Changes []Change `json:"changes"`
+		Err     string   `json:"err"`
+		Code    string   `json:"code"`
+	}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	} else if resp.Err != "" {
+		return nil, codeError(resp.Code, resp.Err)
+	}
+	return resp.Changes, nil
+}
+
+// members of the cluster, as seen by the leader.
+func (cl *cluster) members(ctx context.Context) ([]Member, error) {
+	_, data, err := cl.do(ctx, true, "GET", "/admin/members", "application/json", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := struct {
+		Members []Member `json:"members"`
+		Err     string   `json:"err"`
+		Code    string   `json:"code"`
+	}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	} else if resp.Err != "" {
+		return nil, codeError(resp.Code, resp.Err)
+	}
+	return resp.Members, nil
+}
+
+// changeMember adds a member to the cluster on POST and removes it on
+// DELETE.
+func (cl *cluster) changeMember(ctx context.Context, method string, member Member) error {
+	body, err := json.Marshal(&member)
+	if err != nil {
+		return err
+	}
+	_, data, err := cl.do(ctx, true, method, "/admin/members", "application/json", body, nil)
+	if err != nil {
+		return err
+	}
+	resp := struct {
+		Err  string `json:"err"`
+		Code string `json:"code"`
+	}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	} else if resp.Err != "" {
+		return codeError(resp.Code, resp.Err)
+	}
+	return nil
+}
+
+// watch changes under prefix after fromCAS, resuming the watch when the
+// connection is lost, until quit is closed or server refuses to watch.
+// Resumed watch replays the last received write from server's history,
+// server refuses with ErrorCompacted if history no longer has it. Watch
+// from nullCAS that is lost before receiving any change is resumed from
+// the CAS at which the first stream started.
+func (cl *cluster) watch(
+	prefix string, fromCAS uint64,
+	changes chan<- Change, quit <-chan struct{}) {
+
+	defer close(changes)
+
+	// a single write can notify several changes with same CAS, to resume
+	// from a partially received write skip changes already received.
+	var lastCAS uint64
+	var skip, seen int
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		donech := make(chan struct{})
+		go func() {
+			select {
+			case <-quit:
+				cancel()
+			case <-donech:
+			}
+		}()
+		query := url.Values{"prefix": {prefix}}
+		query.Set("CAS", strconv.FormatUint(fromCAS, 10))
+		startCAS, err := cl.watchStream(ctx, query, func(change Change) bool {
+			CAS := uint64(change.CAS)
+			if skip > 0 && CAS == lastCAS {
+				skip--
+				return true
+			} else if CAS != lastCAS {
+				lastCAS, seen, skip = CAS, 0, 0
+			}
+			select {
+			case changes <- change:
+				seen++
+				return true
+			case <-quit:
+				return false
+			}
+		})
+		close(donech)
+		cancel()
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(watchRetryInterval):
+		}
+		if lastCAS != uint64(nullCAS) {
+			fromCAS, skip = lastCAS-1, seen
+		} else if fromCAS == uint64(nullCAS) {
+			fromCAS = startCAS
+		}
+	}
+}
+
+// watchStream reads changes from server and calls fn for each change, until
+// connection is closed or fn returns false. Return the CAS at which server
+// started the watch, nullCAS if not known, and error only if server
+// refused to watch.
+func (cl *cluster) watchStream(
+	ctx context.Context, query url.Values,
+	fn func(Change) bool) (startCAS uint64, err error) {
+
+	uri := cl.pickServer() + "/dict/watch?" + query.Encode()
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return uint64(nullCAS), err
+	}
+	htresp, err := cl.httpc.Do(req.WithContext(ctx))
+	if err != nil {
+		return uint64(nullCAS), nil
+	}
+	defer htresp.Body.Close()
+	startCAS, _ = strconv.ParseUint(htresp.Header.Get(HttpHdrNameCAS), 10, 64)
+
+	dec := json.NewDecoder(htresp.Body)
+	for {
+		var msg struct {
+			Change
+			Err  string `json:"err"`
+			Code string `json:"code"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return startCAS, nil
+		} else if msg.Err != "" {
+			return startCAS, codeError(msg.Code, msg.Err)
+		} else if fn(msg.Change) == false {
+			return startCAS, nil
+		}
+	}
+}
+
+// isIdempotent return true if request can be retried after it reached the
+// server.
+func isIdempotent(method string) bool {
+	return method == "GET" || method == "HEAD"
+}
+
+// isDialError return true if request failed to connect with the server,
+// hence it never reached the server.
+func isDialError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	operr, ok := err.(*net.OpError)
+	return ok && operr.Op == "dial"
+}
+
+// isNotLeader return true if server refused the request as it is not the
+// leader.
+func isNotLeader(data []byte) bool {
+	var resp struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(data, &resp)
+	return resp.Code == ErrorCodeNotLeader
+}
@@ -0,0 +1,32 @@
+package failsafe
+
+import (
+	"github.com/goraft/raft"
+)
+
+// CompactCommand to compact older versions of SafeDict.
+type CompactCommand struct {
+	CAS float64 `json:"CAS"`
+}
+
+// NewCompactCommand creates a new instance of CompactCommand.
+func NewCompactCommand(cas float64) *CompactCommand {
+	return &CompactCommand{cas}
+}
+
+// CommandName implements raft.Command interface.
+func (c *CompactCommand) CommandName() string {
+	return "compact"
+}
+
+// Apply implements raft.CommandApply interface.
+func (c *CompactCommand) Apply(context raft.Context) (interface{}, error) {
+	s := context.Server().Context().(*Server)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
+	nextCAS, err := s.db.Compact(c.CAS)
+	return nextCAS, err
+}
@@ -4,11 +4,130 @@ package failsafe
 
 import (
 	"path/filepath"
+	"time"
 )
 
 const HttpHdrNameLeader = "go-failsafe-leader"
 const HttpHdrNameLeaderAddr = "go-failsafe-leaderAddr"
 
+// HttpHdrNameForwarded marks requests forwarded by a follower to the
+// leader, such requests are not forwarded again.
+const HttpHdrNameForwarded = "go-failsafe-forwarded"
+
+// HttpHdrNameCAS carries dictionary's CAS in responses that use ETag for
+// field's CAS, and in watch responses the CAS at which watch started.
+const HttpHdrNameCAS = "go-failsafe-CAS"
+
+// ForwardProxy followers proxy writes to the leader.
+const ForwardProxy = "proxy"
+
+// ForwardRedirect followers redirect writes to the leader with
+// 307 Temporary Redirect.
+const ForwardRedirect = "redirect"
+
+// ForwardNone followers fail writes with not leader error.
+const ForwardNone = "none"
+
+// HttpMimeJSONPatch is the content-type for RFC 6902 JSON Patch document.
+const HttpMimeJSONPatch = "application/json-patch+json"
+
+// HttpMimeMergePatch is the content-type for RFC 7386 JSON Merge Patch
+// document.
+const HttpMimeMergePatch = "application/merge-patch+json"
+
+// ConsistencyStale reads are served from the local dictionary, which can
+// be behind the leader.
+const ConsistencyStale = "stale"
+
+// ConsistencyLeader reads are served only by the node that believes it is
+// the leader, a deposed leader might still serve stale data.
+const ConsistencyLeader = "leader"
+
+// ConsistencyLinearizable reads are served by the leader after committing
+// a no-op entry through raft, confirming that it still holds quorum and
+// has applied every write acknowledged before the read.
+const ConsistencyLinearizable = "linearizable"
+
+// RoleLeader for the member that is the leader of the cluster.
+const RoleLeader = "leader"
+
+// RoleFollower for members that replicate the log from the leader.
+const RoleFollower = "follower"
+
+// RoleLearner for members that replicate the dictionary without voting.
+const RoleLearner = "learner"
+
+// defaultHistorySize is the number of recent changes remembered by
+// Server's dictionary.
+const defaultHistorySize = 1024
+
+// defaultVersionWindow is the number of recent CAS for which older versions
+// are retained by Server's dictionary.
+const defaultVersionWindow = float64(1024)
+
+// watchChanSize is the number of changes buffered for a watcher, before it
+// is dropped as slow.
+const watchChanSize = 1024
+
+// watchRetryInterval is the time to wait before resuming a lost watch.
+const watchRetryInterval = 100 * time.Millisecond
+
+// clientRetries is the number of times a client request is retried, on
+// failure, before giving up.
+const clientRetries = 5
+
+// clientBackoff is the initial time to wait before retrying a failed
+// client request, doubled on every retry.
+const clientBackoff = 50 * time.Millisecond
+
+// clientMaxBackoff is the maximum time to wait before retrying a failed
+// client request.
+const clientMaxBackoff = 2 * time.Second
+
+// clientCacheSize is the maximum number of field values cached by
+// SafeDictClient for revalidation.
+const clientCacheSize = 1024
+
+// leaseCheckInterval is the interval at which leader checks for expired
+// leases.
+const leaseCheckInterval = 100 * time.Millisecond
+
+// defaultSnapshotCommits is the number of commits after which a snapshot
+// is taken.
+const defaultSnapshotCommits = 10000
+
+// defaultSnapshotInterval is the time after which a snapshot is taken, if
+// there were any commits.
+const defaultSnapshotInterval = 30 * time.Minute
+
+// defaultSnapshotLogSize is the size of raft log in bytes beyond which a
+// snapshot is taken.
+const defaultSnapshotLogSize = 64 * 1024 * 1024
+
+// snapshotCheckInterval is the interval at which snapshot policy is
+// evaluated.
+const snapshotCheckInterval = time.Second
+
+// learnerRetryInterval is the time to wait before a learner bootstraps
+// again.
+const learnerRetryInterval = time.Second
+
+// learnerHeartbeat is the interval at which learners register with the
+// leader.
+const learnerHeartbeat = time.Second
+
+// learnerExpiry is the time after which leader forgets a learner that
+// failed to register.
+const learnerExpiry = 10 * learnerHeartbeat
+
+// transferTimeout is the time to wait for the target to catch up, and
+// again for it to become the leader, when transferring leadership.
+const transferTimeout = 5 * time.Second
+
+// transferPollInterval is the interval at which progress of leadership
+// transfer is checked.
+const transferPollInterval = 50 * time.Millisecond
+
 // snapshotFile returns the file and its path to persist SafeDict on disk.
 func snapshotFile(path string) string {
 	return filepath.Join(path, "safedict.snapshot")
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var testdir = "testdata"
@@ -27,6 +28,13 @@ var activeServers map[string][]interface{}
 func init() {
 	raft.RegisterCommand(&SetCommand{})
 	raft.RegisterCommand(&DeleteCommand{})
+	raft.RegisterCommand(&PatchCommand{})
+	raft.RegisterCommand(&MergeCommand{})
+	raft.RegisterCommand(&TxnCommand{})
+	raft.RegisterCommand(&CompactCommand{})
+	raft.RegisterCommand(&GrantLeaseCommand{})
+	raft.RegisterCommand(&RevokeLeaseCommand{})
+	raft.RegisterCommand(&RestoreCommand{})
 	activeServers = make(map[string][]interface{})
 }
 
@@ -59,3 +67,56 @@ func startTestServer(servdir string) (*Server, net.Listener, *http.Server, error
 	activeServers[servdir] = []interface{}{srv, lis, daemon}
 	return srv, lis, daemon, nil
 }
+
+// testRaftServer fakes the raft state of a server, for testing handlers
+// without a raft cluster. Methods that are not faked shall panic.
+type testRaftServer struct {
+	raft.Server
+	name, leader, state string
+	peers               map[string]*raft.Peer
+	term, commitIndex   uint64
+	electionTimeout     time.Duration
+	// error returned by TakeSnapshot and number of times Stop is called.
+	snapshotErr error
+	stops       int
+	// context of raft-server, and its state machine saved by TakeSnapshot.
+	context      interface{}
+	stateMachine raft.StateMachine
+	snapshot     []byte
+}
+
+func (rs *testRaftServer) Name() string                 { return rs.name }
+func (rs *testRaftServer) Leader() string               { return rs.leader }
+func (rs *testRaftServer) State() string                { return rs.state }
+func (rs *testRaftServer) Peers() map[string]*raft.Peer { return rs.peers }
+func (rs *testRaftServer) Term() uint64                 { return rs.term }
+func (rs *testRaftServer) CommitIndex() uint64          { return rs.commitIndex }
+func (rs *testRaftServer) HeartbeatInterval() time.Duration {
+	return 50 * time.Millisecond
+}
+func (rs *testRaftServer) ElectionTimeout() time.Duration { return rs.electionTimeout }
+func (rs *testRaftServer) SetElectionTimeout(timeout time.Duration) {
+	rs.electionTimeout = timeout
+}
+func (rs *testRaftServer) Stop()                { rs.stops, rs.state = rs.stops+1, raft.Stopped }
+func (rs *testRaftServer) FlushCommitIndex()    {}
+func (rs *testRaftServer) Context() interface{} { return rs.context }
+func (rs *testRaftServer) TakeSnapshot() error {
+	if rs.snapshotErr != nil || rs.stateMachine == nil {
+		return rs.snapshotErr
+	}
+	snapshot, err := rs.stateMachine.Save()
+	rs.snapshot = snapshot
+	return err
+}
+
+// testContext fakes the raft context for applying commands at index.
+type testContext struct {
+	raft.Context
+	server raft.Server
+	index  uint64
+}
+
+func (c *testContext) Server() raft.Server  { return c.server }
+func (c *testContext) CurrentIndex() uint64 { return c.index }
+func (c *testContext) CommitIndex() uint64  { return c.index }
@@ -24,6 +24,12 @@ func (c *DeleteCommand) CommandName() string {
 // Apply implements raft.CommandApply interface.
 func (c *DeleteCommand) Apply(context raft.Context) (interface{}, error) {
 	s := context.Server().Context().(*Server)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
 	nextCAS, err := s.db.Delete(c.Path, c.CAS)
+	s.countConflict(err)
 	return nextCAS, err
 }
@@ -3,6 +3,8 @@ package failsafe
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/prataprc/go-jsonpointer"
@@ -19,20 +21,44 @@ var ErrorInvalidType = fmt.Errorf("safedict.errorInvalidType")
 // ErrorInvalidCAS
 var ErrorInvalidCAS = fmt.Errorf("safedict.errorInvalidCAS")
 
+// ErrorInvalidPatch
+var ErrorInvalidPatch = fmt.Errorf("safedict.errorInvalidPatch")
+
+// ErrorPatchTest
+var ErrorPatchTest = fmt.Errorf("safedict.errorPatchTest")
+
+// ErrorInvalidTxn
+var ErrorInvalidTxn = fmt.Errorf("safedict.errorInvalidTxn")
+
+// ErrorCompacted
+var ErrorCompacted = fmt.Errorf("safedict.errorCompacted")
+
+// ErrorLeaseNotFound
+var ErrorLeaseNotFound = fmt.Errorf("safedict.errorLeaseNotFound")
+
 const nullCAS = float64(0)
 
 // SafeDict is a failsafe data-structure similar to JSON property.
 type SafeDict struct {
-	mu  sync.Mutex             `json:"-"`
-	m   map[string]interface{} `json:"m"`   // JSON decoded data-structure
-	CAS float64                `json:"CAS"` // monotonically increasing CAS
+	mu   sync.Mutex             `json:"-"`
+	m    map[string]interface{} `json:"m"`   // JSON decoded data-structure
+	CAS  float64                `json:"CAS"` // monotonically increasing CAS
+	revs revisions              // per field revisions
+	// watchers for changes, indexed by watch-id.
+	watchers map[uint64]*watcher `json:"-"`
+	watchid  uint64              `json:"-"`
+	history  *history            `json:"-"` // recent changes
+	mvcc     *mvcc               `json:"-"` // older versions
+	leases   *leases             `json:"-"` // granted leases
+	// promoted learner's dictionary, to be replaced by raft.
+	promoted bool `json:"-"`
 }
 
 // NewSafeDict returns a reference to new failsafe dictionary. Can be
 // initialized to support CAS and/or initialized with initial JSON encoded
 // string or JSON decode map[string]interface{} data.
 func NewSafeDict(data interface{}, cas bool) (*SafeDict, error) {
-	sd := &SafeDict{}
+	sd := &SafeDict{watchers: make(map[uint64]*watcher)}
 	sd.m = make(map[string]interface{})
 
 	switch arg := data.(type) {
@@ -57,22 +83,37 @@ func NewSafeDict(data interface{}, cas bool) (*SafeDict, error) {
 	if cas {
 		sd.CAS = float64(1)
 	}
+	sd.revs = newRevisions(sd.m, sd.CAS)
+	sd.history = newHistory(0, sd.CAS)
+	sd.mvcc = newMVCC(sd.CAS, 0)
+	sd.leases = newLeases(0, nil)
 	return sd, nil
 }
 
 // MarshalJSON implements encoding/json.Marshaler interface.
 func (sd *SafeDict) MarshalJSON() ([]byte, error) {
+	var ls map[int64]*Lease
+	var leaseID int64
+	if sd.leases != nil {
+		ls, leaseID = sd.leases.leases, sd.leases.nextid
+	}
 	return json.Marshal(struct {
-		M   map[string]interface{} `json:"m"`
-		CAS float64                `json:"CAS"`
-	}{sd.m, sd.CAS})
+		M       map[string]interface{} `json:"m"`
+		CAS     float64                `json:"CAS"`
+		Revs    revisions              `json:"revs"`
+		Leases  map[int64]*Lease       `json:"leases"`
+		LeaseID int64                  `json:"leaseID"`
+	}{sd.m, sd.CAS, sd.revs, ls, leaseID})
 }
 
 // UnmarshalJSON implements encoding/json.Unmarshaler interface.
 func (sd *SafeDict) UnmarshalJSON(data []byte) error {
 	t := struct {
-		M   map[string]interface{} `json:"m"`
-		CAS float64                `json:"CAS"`
+		M       map[string]interface{} `json:"m"`
+		CAS     float64                `json:"CAS"`
+		Revs    revisions              `json:"revs"`
+		Leases  map[int64]*Lease       `json:"leases"`
+		LeaseID int64                  `json:"leaseID"`
 	}{}
 
 	if err := json.Unmarshal(data, &t); err != nil {
@@ -80,6 +121,11 @@ func (sd *SafeDict) UnmarshalJSON(data []byte) error {
 	}
 	sd.m = t.M
 	sd.CAS = t.CAS
+	sd.revs = t.Revs
+	if sd.revs == nil { // snapshots without revisions.
+		sd.revs = newRevisions(sd.m, sd.CAS)
+	}
+	sd.leases = newLeases(t.LeaseID, t.Leases)
 	return nil
 }
 
@@ -104,58 +150,237 @@ func (sd *SafeDict) Get(path string) (rv interface{}, CAS float64, err error) {
 	return rv, sd.CAS, nil
 }
 
+// GetRev is same as Get, additionally returns the revision of the field.
+func (sd *SafeDict) GetRev(path string) (rv interface{}, CAS float64, rev Revision, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	rv = jsonpointer.Get(sd.m, path)
+	if rv == nil {
+		return nil, nullCAS, Revision{}, ErrorInvalidPath
+	}
+	return rv, sd.CAS, sd.revision(path), nil
+}
+
 // Set value at the specified path, full json-pointer spec. is allowed. If CAS
-// is specified as nullCAS, CAS is ignored. Set is an idempotent operation.
+// is specified as nullCAS, CAS is ignored, otherwise field at path shall not
+// be modified after CAS. Set is an idempotent operation.
 func (sd *SafeDict) Set(path string, value interface{}, CAS float64) (nextCAS float64, err error) {
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	if sd.checkCAS(CAS) == false {
+	return sd.set(path, value, CAS)
+}
+
+func (sd *SafeDict) set(path string, value interface{}, CAS float64) (nextCAS float64, err error) {
+	if sd.checkRev(path, CAS) == false {
 		return nullCAS, ErrorInvalidCAS
 	}
 
-	if path == "" {
-		if m, ok := value.(map[string]interface{}); ok {
-			sd.m = m
-			return sd.incrementCAS(), nil
+	doc := value
+	if path != "" {
+		if doc, err = patchReplace(sd.m, path, value); err != nil {
+			return nullCAS, err
 		}
-		return nullCAS, ErrorInvalidType
 	}
-	if err = jsonpointer.Set(sd.m, path, value); err == nil {
-		return sd.incrementCAS(), nil
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nullCAS, ErrorInvalidType
 	}
-	return nullCAS, err
+	oldm := sd.m
+	sd.m = m
+	changes := []Change{{Path: path, Op: "set"}}
+	return sd.commit(oldm, []string{path}, changes), nil
 }
 
 // Delete value at the specified path, last segment shall always index
-// into json property. If CAS is specied as nullCAS, CAS is ignored. Delete is
-// an idempotent operation.
+// into json property. If CAS is specied as nullCAS, CAS is ignored,
+// otherwise field at path shall not be modified after CAS. Delete is an
+// idempotent operation, deleting a missing field is a no-op and deleting
+// the root leaves an empty dictionary.
 func (sd *SafeDict) Delete(path string, CAS float64) (nextCAS float64, err error) {
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	if sd.checkCAS(CAS) == false {
+	if sd.checkRev(path, CAS) == false {
 		return nullCAS, ErrorInvalidCAS
 	}
 
+	var doc interface{}
 	if path == "" {
-		sd.m = nil
-		return sd.incrementCAS(), nil
+		doc = make(map[string]interface{})
+	} else if _, ok := sd.lookup(path); !ok {
+		return sd.CAS, nil // nothing to delete.
+	} else if doc, err = deleteField(sd.m, path); err != nil {
+		return nullCAS, err
 	}
-	if err = jsonpointer.Delete(sd.m, path); err == nil {
-		return sd.incrementCAS(), nil
+	oldm := sd.m
+	sd.m, _ = doc.(map[string]interface{})
+	changes := []Change{{Path: path, Op: "delete"}}
+	return sd.commit(oldm, []string{path}, changes), nil
+}
+
+// Patch applies RFC 6902 JSON Patch document, specified as list of
+// operations, on the dictionary. Operations are applied atomically, if any
+// of them fail the dictionary is left untouched. If CAS is specified as
+// nullCAS, CAS is ignored.
+func (sd *SafeDict) Patch(ops []PatchOp, CAS float64) (nextCAS float64, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if sd.checkCAS(CAS) == false {
+		return nullCAS, ErrorInvalidCAS
+	}
+
+	doc, err := applyPatch(sd.m, ops)
+	if err != nil {
+		return nullCAS, err
+	}
+	oldm := sd.m
+	switch m := doc.(type) {
+	case map[string]interface{}:
+		sd.m = m
+	case nil: // removing the root leaves an empty dictionary, as Delete.
+		sd.m = make(map[string]interface{})
+	default:
+		return nullCAS, ErrorInvalidType
+	}
+	paths := make([]string, 0, len(ops))
+	for _, op := range ops {
+		switch op.Op {
+		case "move":
+			paths = append(paths, op.From, op.Path)
+		case "test":
+		default:
+			paths = append(paths, op.Path)
+		}
+	}
+	// changes are notified for touched fields, as their values after the
+	// patch, fields removed by the patch are notified as deleted.
+	touched := touchedPaths(oldm, paths)
+	changes := make([]Change, 0, len(touched))
+	for _, path := range touched {
+		if _, ok := sd.lookup(path); ok {
+			changes = append(changes, Change{Path: path, Op: "patch"})
+		} else {
+			changes = append(changes, Change{Path: path, Op: "delete"})
+		}
 	}
-	return nullCAS, err
+	return sd.commit(oldm, paths, changes), nil
+}
+
+// Merge applies RFC 7386 JSON Merge Patch on the value located by `path`
+// jsonpointer, full json-pointer spec. is allowed. If value is not present
+// at path, it is created. If CAS is specified as nullCAS, CAS is ignored,
+// otherwise field at path shall not be modified after CAS.
+func (sd *SafeDict) Merge(path string, patch interface{}, CAS float64) (nextCAS float64, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if sd.checkRev(path, CAS) == false {
+		return nullCAS, ErrorInvalidCAS
+	}
+
+	var doc interface{} = sd.m
+	target, ok := sd.lookup(path)
+	value := mergePatch(target, patch)
+	if ok && path != "" {
+		doc, _, err = patchRemove(doc, path)
+	}
+	if err == nil {
+		doc, err = patchAdd(doc, path, value)
+	}
+	if err != nil {
+		return nullCAS, err
+	}
+	if m, ok := doc.(map[string]interface{}); ok {
+		oldm := sd.m
+		sd.m = m
+		changes := []Change{{Path: path, Op: "merge"}}
+		return sd.commit(oldm, mergePaths(path, target, patch), changes), nil
+	}
+	return nullCAS, ErrorInvalidType
+}
+
+// Txn evaluates compare conditions of the transaction and applies `Then`
+// operations if all of them succeed, else applies `Else` operations.
+// Operations are applied atomically and CAS is incremented only once.
+func (sd *SafeDict) Txn(txn *Txn) (result TxnResult, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if result.Succeeded, err = txn.evaluate(sd); err != nil {
+		return TxnResult{CAS: nullCAS}, err
+	}
+	ops := txn.Else
+	if result.Succeeded {
+		ops = txn.Then
+	}
+	if len(ops) == 0 {
+		result.CAS = sd.CAS
+		return result, nil
+	}
+
+	m := sd.m
+	for _, op := range ops {
+		if m, err = op.apply(m); err != nil {
+			return TxnResult{CAS: nullCAS}, err
+		}
+	}
+	paths := make([]string, 0, len(ops))
+	changes := make([]Change, 0, len(ops))
+	for _, op := range ops {
+		_, oldok := sd.lookup(op.Path)
+		_, newok := lookupField(m, op.Path)
+		if op.Op == "delete" && op.Path != "" && !oldok && !newok {
+			continue // deleted a missing field.
+		}
+		paths = append(paths, op.Path)
+		changes = appendChange(changes, op.Path, op.Op)
+	}
+	if len(paths) == 0 {
+		result.CAS = sd.CAS
+		return result, nil
+	}
+	oldm := sd.m
+	sd.m = m
+	result.CAS = sd.commit(oldm, paths, changes)
+	return result, nil
 }
 
 // Save implements raft.StateMachine interface.
 func (sd *SafeDict) Save() (data []byte, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
 	return json.Marshal(sd)
 }
 
-// Restore implements raft.StateMachine interface.
+// Recovery implements raft.StateMachine interface. History of changes and
+// older versions are not part of the snapshot, hence they are dropped.
+// Watchers are dropped too, as they cannot be notified about changes
+// recovered from snapshot.
 func (sd *SafeDict) Recovery(data []byte) (err error) {
-	return json.Unmarshal(data, &sd)
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if err = json.Unmarshal(data, &sd); err != nil {
+		return err
+	}
+	size, window := 0, float64(0)
+	if sd.history != nil {
+		size = len(sd.history.changes)
+	}
+	if sd.mvcc != nil {
+		window = sd.mvcc.window
+	}
+	sd.history = newHistory(size, sd.CAS)
+	sd.mvcc = newMVCC(sd.CAS, window)
+	sd.promoted = false
+	for id, w := range sd.watchers {
+		delete(sd.watchers, id)
+		close(w.ch)
+	}
+	return nil
 }
 
 // monotonically increasing CAS.
@@ -166,6 +391,128 @@ func (sd *SafeDict) incrementCAS() float64 {
 	return sd.CAS
 }
 
+// lookup field at path, ok is false if field is not present.
+func (sd *SafeDict) lookup(path string) (value interface{}, ok bool) {
+	return lookupField(sd.m, path)
+}
+
+// revision of field at path, if field is missing revision of its nearest
+// parent is returned.
+func (sd *SafeDict) revision(path string) Revision {
+	for path != "" {
+		if _, ok := sd.lookup(path); ok {
+			return sd.revs.lookup(path)
+		}
+		path = path[:strings.LastIndex(path, "/")]
+	}
+	rev := sd.revs.lookup("")
+	rev.Modify = sd.CAS
+	return rev
+}
+
+// commit a write that changed the dictionary from oldm, by incrementing
+// the CAS, updating the revisions of fields at `paths` and remembering
+// their old versions, remembering `changes` in history and notifying them
+// to watchers.
+func (sd *SafeDict) commit(
+	oldm map[string]interface{}, paths []string, changes []Change) float64 {
+
+	CAS := sd.incrementCAS()
+	sd.touch(oldm, paths, CAS)
+	if sd.history == nil {
+		sd.history = newHistory(0, CAS)
+	}
+	if len(sd.history.changes) > 0 || len(sd.watchers) > 0 {
+		for i := range changes {
+			// dictionary is never modified in place, refer updateAt, hence
+			// values can be shared with older and newer versions.
+			changes[i].CAS = CAS
+			changes[i].OldValue, _ = lookupField(oldm, changes[i].Path)
+			changes[i].NewValue, _ = sd.lookup(changes[i].Path)
+		}
+		sd.history.add(changes, CAS)
+		sd.notify(changes)
+	}
+	return CAS
+}
+
+// touch fields at paths, whose values were changed from oldm at CAS, by
+// updating their revisions, remembering their old versions and detaching
+// them, and their children, from their leases.
+func (sd *SafeDict) touch(oldm map[string]interface{}, paths []string, CAS float64) {
+	if sd.mvcc == nil {
+		sd.mvcc = newMVCC(CAS, 0)
+	}
+	for _, path := range touchedPaths(oldm, paths) {
+		oldv, oldok := lookupField(oldm, path)
+		newv, newok := sd.lookup(path)
+		sd.revs.update(path, oldv, oldok, newv, newok, CAS)
+		sd.mvcc.add(CAS, path, oldv, oldok)
+		sd.leases.detach(path)
+	}
+}
+
+// lookupField at path in m, ok is false if field is not present.
+func lookupField(m map[string]interface{}, path string) (value interface{}, ok bool) {
+	if m == nil {
+		return nil, false
+	}
+	return patchGet(m, path)
+}
+
+// deleteField at path from doc and return the updated document, last
+// segment shall index into json property.
+func deleteField(doc interface{}, path string) (interface{}, error) {
+	parent, _ := patchGet(doc, path[:strings.LastIndex(path, "/")])
+	if _, ok := parent.(map[string]interface{}); !ok {
+		return nil, ErrorInvalidPath
+	}
+	doc, _, err := patchRemove(doc, path)
+	return doc, err
+}
+
+// touchedPaths by a write that changed the dictionary from oldm. Writing
+// an array element touches the array, as elements following it can shift,
+// and paths under another touched path are dropped.
+func touchedPaths(oldm map[string]interface{}, paths []string) []string {
+	touched := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			parent, _ := lookupField(oldm, path[:i])
+			if _, ok := parent.([]interface{}); ok || path[i+1:] == "-" {
+				path = path[:i]
+			}
+		}
+		touched = append(touched, path)
+	}
+	sort.Strings(touched)
+	n := 0
+loop:
+	for _, path := range touched {
+		for _, prefix := range touched[:n] {
+			if isPrefixPath(prefix, path) {
+				continue loop
+			}
+		}
+		touched[n] = path
+		n++
+	}
+	return touched[:n]
+}
+
+// compare API supplied CAS with the revision of field at path, provided API
+// supplied CAS is not nullCAS. CAS is valid if field is not modified after
+// CAS, local CAS is always valid.
+func (sd *SafeDict) checkRev(path string, CAS float64) bool {
+	switch {
+	case CAS == nullCAS, CAS == sd.CAS:
+		return true
+	case CAS > sd.CAS:
+		return false
+	}
+	return sd.revision(path).Modify <= CAS
+}
+
 // compare local CAS with API supplied CAS, provided API supplied CAS is not
 // nullCAS.
 func (sd *SafeDict) checkCAS(CAS float64) bool {
@@ -204,6 +204,63 @@ func TestDeleteSafeDict(t *testing.T) {
 	}
 }
 
+func TestDeleteSafeDictRev(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": {"b": 1}, "c": 2}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.Delete("/a/b", nullCAS)
+	if _, _, err := sd.Get("/a/b"); err != ErrorInvalidPath {
+		t.Fatal("expected ErrorInvalidPath", err)
+	} else if _, _, rev, _ := sd.GetRev("/a"); rev != (Revision{1, 2}) {
+		t.Fatal("unexpected revision", rev)
+	}
+	sd.Delete("/a", nullCAS)
+	sd.Delete("/c", nullCAS)
+	if CAS := sd.GetCAS(); CAS != 4 {
+		t.Fatal("unexpected CAS", CAS)
+	} else if _, _, rev, _ := sd.GetRev(""); rev != (Revision{1, 4}) {
+		t.Fatal("unexpected revision", rev)
+	} else if _, _, _, err := sd.GetRev("/a"); err != ErrorInvalidPath {
+		t.Fatal("expected ErrorInvalidPath", err)
+	}
+}
+
+func TestDeleteSafeDictIdempotent(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": {"b": 1}}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// deleting a missing field is a no-op.
+	if CAS, err := sd.Delete("/x", nullCAS); err != nil {
+		t.Fatal(err)
+	} else if CAS != 1 {
+		t.Fatal("unexpected CAS", CAS)
+	} else if CAS, err = sd.Delete("/a/x", nullCAS); err != nil || CAS != 1 {
+		t.Fatal("unexpected CAS", CAS, err)
+	}
+
+	// deleting the root is same as deleting it within a transaction.
+	txnsd, _ := NewSafeDict([]byte(`{"a": {"b": 1}}`), true)
+	txn := &Txn{Then: []TxnOp{OpDelete("")}}
+	if result, err := txnsd.Txn(txn); err != nil || !result.Succeeded {
+		t.Fatal("unexpected result", result, err)
+	}
+	if _, err := sd.Delete("", nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	val, _, err := sd.Get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	txnval, _, _ := txnsd.Get("")
+	if !reflect.DeepEqual(val, map[string]interface{}{}) {
+		t.Fatal("unexpected root", val)
+	} else if !reflect.DeepEqual(val, txnval) {
+		t.Fatal("unexpected root", val, txnval)
+	}
+}
+
 func BenchmarkGetSafeDict1(b *testing.B) {
 	sd, _ := NewSafeDict(smallJSON, true)
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,133 @@
+// Errors across the wire.
+//
+// Every JSON response carries the error string in `err` field and a
+// stable error code in `code` field, both are empty on success. Response's
+// HTTP status is picked by the error code. Clients use the error code to
+// rehydrate the sentinel errors, so that callers can compare them.
+
+package failsafe
+
+import (
+	"errors"
+	"github.com/goraft/raft"
+	"net/http"
+)
+
+// ErrorCodeInvalidPath for ErrorInvalidPath.
+const ErrorCodeInvalidPath = "invalid-path"
+
+// ErrorCodeInvalidType for ErrorInvalidType.
+const ErrorCodeInvalidType = "invalid-type"
+
+// ErrorCodeCASMismatch for ErrorInvalidCAS.
+const ErrorCodeCASMismatch = "cas-mismatch"
+
+// ErrorCodeNotLeader for raft.NotLeaderError.
+const ErrorCodeNotLeader = "not-leader"
+
+// ErrorCodeTimeout for raft.CommandTimeoutError.
+const ErrorCodeTimeout = "timeout"
+
+// ErrorCodeCompacted for ErrorCompacted.
+const ErrorCodeCompacted = "compacted"
+
+// ErrorCodeInvalidPatch for ErrorInvalidPatch.
+const ErrorCodeInvalidPatch = "invalid-patch"
+
+// ErrorCodePatchTest for ErrorPatchTest.
+const ErrorCodePatchTest = "patch-test"
+
+// ErrorCodeInvalidTxn for ErrorInvalidTxn.
+const ErrorCodeInvalidTxn = "invalid-txn"
+
+// ErrorCodeLeaseNotFound for ErrorLeaseNotFound.
+const ErrorCodeLeaseNotFound = "lease-not-found"
+
+// ErrorCodeInvalidConsistency for ErrorInvalidConsistency.
+const ErrorCodeInvalidConsistency = "invalid-consistency"
+
+// ErrorCodeNotFresh for ErrorNotFresh.
+const ErrorCodeNotFresh = "not-fresh"
+
+// ErrorCodeMemberNotFound for ErrorMemberNotFound.
+const ErrorCodeMemberNotFound = "member-not-found"
+
+// ErrorCodeNotLearner for ErrorNotLearner.
+const ErrorCodeNotLearner = "not-learner"
+
+// ErrorCodeTransferFailed for ErrorTransferFailed.
+const ErrorCodeTransferFailed = "transfer-failed"
+
+// ErrorCodeNotReady for ErrorNotReady.
+const ErrorCodeNotReady = "not-ready"
+
+// ErrorCodeInvalidBackup for ErrorInvalidBackup.
+const ErrorCodeInvalidBackup = "invalid-backup"
+
+// ErrorCodeLearnerBehind for ErrorLearnerBehind.
+const ErrorCodeLearnerBehind = "learner-behind"
+
+// ErrorCodeInternal for all other errors.
+const ErrorCodeInternal = "internal"
+
+var errorCodes = []struct {
+	code   string
+	status int
+	err    error
+}{
+	{ErrorCodeInvalidPath, http.StatusNotFound, ErrorInvalidPath},
+	{ErrorCodeInvalidType, http.StatusBadRequest, ErrorInvalidType},
+	{ErrorCodeCASMismatch, http.StatusConflict, ErrorInvalidCAS},
+	{ErrorCodeNotLeader, http.StatusServiceUnavailable, raft.NotLeaderError},
+	{ErrorCodeTimeout, http.StatusGatewayTimeout, raft.CommandTimeoutError},
+	{ErrorCodeCompacted, http.StatusGone, ErrorCompacted},
+	{ErrorCodeInvalidPatch, http.StatusBadRequest, ErrorInvalidPatch},
+	{ErrorCodePatchTest, http.StatusConflict, ErrorPatchTest},
+	{ErrorCodeInvalidTxn, http.StatusBadRequest, ErrorInvalidTxn},
+	{ErrorCodeLeaseNotFound, http.StatusNotFound, ErrorLeaseNotFound},
+	{ErrorCodeInvalidConsistency, http.StatusBadRequest, ErrorInvalidConsistency},
+	{ErrorCodeNotFresh, http.StatusConflict, ErrorNotFresh},
+	{ErrorCodeMemberNotFound, http.StatusNotFound, ErrorMemberNotFound},
+	{ErrorCodeNotLearner, http.StatusConflict, ErrorNotLearner},
+	{ErrorCodeTransferFailed, http.StatusServiceUnavailable, ErrorTransferFailed},
+	{ErrorCodeNotReady, http.StatusServiceUnavailable, ErrorNotReady},
+	{ErrorCodeInvalidBackup, http.StatusBadRequest, ErrorInvalidBackup},
+	{ErrorCodeLearnerBehind, http.StatusConflict, ErrorLearnerBehind},
+}
+
+// errorCode return the stable code for err, empty string if err is nil.
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, x := range errorCodes {
+		if x.err == err {
+			return x.code
+		}
+	}
+	return ErrorCodeInternal
+}
+
+// errorStatus return the HTTP status for error code.
+func errorStatus(code string) int {
+	if code == "" {
+		return http.StatusOK
+	}
+	for _, x := range errorCodes {
+		if x.code == code {
+			return x.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// codeError rehydrates the error for code, msg is used for errors without
+// a sentinel.
+func codeError(code, msg string) error {
+	for _, x := range errorCodes {
+		if x.code == code {
+			return x.err
+		}
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,42 @@
+package failsafe
+
+import (
+	"fmt"
+	"github.com/goraft/raft"
+	"net/http"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	testcases := []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{nil, "", http.StatusOK},
+		{ErrorInvalidPath, ErrorCodeInvalidPath, http.StatusNotFound},
+		{ErrorInvalidType, ErrorCodeInvalidType, http.StatusBadRequest},
+		{ErrorInvalidCAS, ErrorCodeCASMismatch, http.StatusConflict},
+		{raft.NotLeaderError, ErrorCodeNotLeader, http.StatusServiceUnavailable},
+		{raft.CommandTimeoutError, ErrorCodeTimeout, http.StatusGatewayTimeout},
+		{ErrorCompacted, ErrorCodeCompacted, http.StatusGone},
+		{ErrorNotFresh, ErrorCodeNotFresh, http.StatusConflict},
+		{fmt.Errorf("unknown"), ErrorCodeInternal, http.StatusInternalServerError},
+	}
+	for _, tcase := range testcases {
+		code := errorCode(tcase.err)
+		if code != tcase.code {
+			t.Errorf("expected %q for %v, got %q", tcase.code, tcase.err, code)
+		} else if status := errorStatus(code); status != tcase.status {
+			t.Errorf("expected %v for %q, got %v", tcase.status, code, status)
+		}
+		if tcase.err == nil {
+			continue
+		}
+		if err := codeError(code, tcase.err.Error()); err.Error() != tcase.err.Error() {
+			t.Errorf("expected %v, got %v", tcase.err, err)
+		} else if code != ErrorCodeInternal && err != tcase.err {
+			t.Errorf("expected sentinel %v", tcase.err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Bounded history of recent changes to SafeDict.
+//
+// History is maintained as a ring of changes, once the ring is full oldest
+// changes are evicted and changes upto the evicted CAS are compacted.
+// Watchers and clients that lost their connection can catch up with the
+// dictionary from history, provided they are not behind the compacted CAS.
+// History is disabled for a new SafeDict, Server remembers upto
+// defaultHistorySize changes.
+
+package failsafe
+
+type history struct {
+	changes   []Change // ring of changes
+	start     int      // index of oldest change
+	count     int      // number of changes in the ring
+	compacted float64  // changes upto compacted CAS are not available
+}
+
+func newHistory(size int, CAS float64) *history {
+	return &history{changes: make([]Change, size), compacted: CAS}
+}
+
+// add changes to history, evicting oldest changes when full. CAS is the
+// dictionary's CAS after the changes.
+func (h *history) add(changes []Change, CAS float64) {
+	if len(h.changes) == 0 { // disabled, every change is compacted.
+		return
+	}
+	for _, change := range changes {
+		if h.count == len(h.changes) {
+			h.compacted = h.changes[h.start].CAS
+			h.start = (h.start + 1) % len(h.changes)
+			h.count--
+		}
+		h.changes[(h.start+h.count)%len(h.changes)] = change
+		h.count++
+	}
+}
+
+// since return all changes after CAS.
+func (h *history) since(CAS float64) ([]Change, error) {
+	if CAS < h.compacted || len(h.changes) == 0 {
+		return nil, ErrorCompacted
+	}
+	changes := make([]Change, 0)
+	for i := 0; i < h.count; i++ {
+		change := h.changes[(h.start+i)%len(h.changes)]
+		if change.CAS > CAS {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// SetHistorySize to remember upto `size` recent changes, existing history
+// is compacted. Size of zero disables history.
+func (sd *SafeDict) SetHistorySize(size int) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.history = newHistory(size, sd.CAS)
+}
+
+// GetChangesSince return every change to the dictionary after CAS. If
+// changes after CAS are no longer remembered, ErrorCompacted is returned.
+// Returned changes shall not be modified.
+func (sd *SafeDict) GetChangesSince(CAS float64) ([]Change, error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if CAS >= sd.CAS {
+		return []Change{}, nil
+	}
+	return sd.history.since(CAS)
+}
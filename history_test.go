@@ -0,0 +1,101 @@
+package failsafe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	h := newHistory(3, 1)
+	h.add([]Change{{CAS: 2, Path: "/a"}}, 2)
+	h.add([]Change{{CAS: 3, Path: "/a"}, {CAS: 3, Path: "/b"}}, 3)
+	if changes, err := h.since(1); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 3 {
+		t.Fatal("expected 3 changes", changes)
+	}
+	if changes, err := h.since(2); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 2 || changes[0].CAS != 3 {
+		t.Fatal("expected 2 changes", changes)
+	}
+
+	h.add([]Change{{CAS: 4, Path: "/c"}}, 4)
+	if _, err := h.since(1); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted", err)
+	}
+	ref := []Change{{CAS: 3, Path: "/a"}, {CAS: 3, Path: "/b"}, {CAS: 4, Path: "/c"}}
+	if changes, err := h.since(2); err != nil {
+		t.Fatal(err)
+	} else if reflect.DeepEqual(changes, ref) == false {
+		t.Fatal("unexpected changes", changes)
+	}
+	if changes, err := h.since(4); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 0 {
+		t.Fatal("expected no changes", changes)
+	}
+
+	// one of the changes for CAS 3 is evicted.
+	h.add([]Change{{CAS: 5, Path: "/d"}}, 5)
+	if _, err := h.since(2); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted", err)
+	} else if changes, _ := h.since(3); len(changes) != 2 {
+		t.Fatal("expected 2 changes", changes)
+	}
+}
+
+func TestGetChangesSince(t *testing.T) {
+	sd, _ := NewSafeDict([]byte(`{"a": 1}`), true)
+	sd.Set("/a", float64(2), nullCAS)
+	if _, err := sd.GetChangesSince(1); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted without history", err)
+	} else if changes, err := sd.GetChangesSince(2); err != nil {
+		t.Fatal(err)
+	} else if len(changes) != 0 {
+		t.Fatal("expected no changes", changes)
+	}
+
+	sd, _ = NewSafeDict([]byte(`{"a": 1}`), true)
+	sd.SetHistorySize(2)
+	sd.Set("/a", float64(2), nullCAS)
+	sd.Set("/b", "x", nullCAS)
+	sd.Delete("/a", nullCAS)
+
+	if _, err := sd.GetChangesSince(1); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted", err)
+	}
+	ref := []Change{
+		{CAS: 3, Path: "/b", Op: "set", NewValue: "x"},
+		{CAS: 4, Path: "/a", Op: "delete", OldValue: float64(2)},
+	}
+	if changes, err := sd.GetChangesSince(2); err != nil {
+		t.Fatal(err)
+	} else if reflect.DeepEqual(changes, ref) == false {
+		t.Fatal("unexpected changes", changes)
+	}
+
+	// watch replays from history.
+	ch, cancel, err := sd.Watch("/a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if change := <-ch; reflect.DeepEqual(change, ref[1]) == false {
+		t.Fatal("unexpected change", change)
+	}
+	sd.Set("/a", float64(3), nullCAS)
+	if change := <-ch; change.CAS != 5 {
+		t.Fatal("unexpected change", change)
+	}
+
+	// recovery compacts history and drops watchers.
+	data, _ := sd.Save()
+	if err := sd.Recovery(data); err != nil {
+		t.Fatal(err)
+	} else if _, err := sd.GetChangesSince(4); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted", err)
+	} else if _, ok := <-ch; ok {
+		t.Fatal("expected watcher to be dropped")
+	}
+}
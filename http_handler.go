@@ -6,7 +6,13 @@ import (
 	"github.com/goraft/raft"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (s *Server) joinHandler(w http.ResponseWriter, req *http.Request) {
@@ -59,7 +65,7 @@ func (s *Server) dbHandler(w http.ResponseWriter, req *http.Request) {
 	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
 	switch req.Method {
 	case "HEAD":
-		w.Header().Set("ETag", fmt.Sprintf("%v", uint64(s.db.GetCAS())))
+		w.Header().Set("ETag", formatETag(s.db.GetCAS()))
 		x := s.GetLeader()
 		w.Header().Set(HttpHdrNameLeader, x[0])
 		w.Header().Set(HttpHdrNameLeaderAddr, x[1])
@@ -69,10 +75,37 @@ func (s *Server) dbHandler(w http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		} else {
-			value, CAS, err := s.DBGet(jsonreq["path"].(string))
-			w.Header().Set("ETag", fmt.Sprintf("%v", uint64(CAS)))
+			path := jsonreq["path"].(string)
+			consistency := req.URL.Query().Get("consistency")
+			if err := s.SyncRead(consistency); err != nil {
+				m = map[string]interface{}{
+					"err": errorString(err), "code": errorCode(err),
+				}
+				break
+			}
+			if v := req.URL.Query().Get("rev"); v != "" {
+				CAS, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					break
+				}
+				value, err := s.DBGetAt(path, CAS)
+				m = map[string]interface{}{
+					"value": value, "CAS": CAS,
+					"err": errorString(err), "code": errorCode(err),
+				}
+				break
+			}
+			value, CAS, rev, err := s.DBGetRev(path)
+			etag := formatETag(CAS)
+			w.Header().Set("ETag", etag)
+			if err == nil && etagMatch(req.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				break
+			}
 			m = map[string]interface{}{
-				"value": value, "CAS": CAS, "err": errorString(err),
+				"value": value, "CAS": CAS, "rev": rev,
+				"err": errorString(err), "code": errorCode(err),
 			}
 		}
 
@@ -83,8 +116,20 @@ func (s *Server) dbHandler(w http.ResponseWriter, req *http.Request) {
 		} else {
 			path, value := jsonreq["path"].(string), jsonreq["value"]
 			CAS := jsonreq["CAS"].(float64)
-			nextCAS, err := s.DBSetCAS(path, value, CAS)
-			m = map[string]interface{}{"CAS": nextCAS, "err": errorString(err)}
+			var nextCAS float64
+			if CAS, err = s.ifMatchCAS(req, path, CAS); err == ErrorInvalidCAS {
+				// field is missing for `If-Match: *`.
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				break
+			} else if leaseID, ok := jsonreq["lease"].(float64); ok && leaseID != 0 {
+				nextCAS, err = s.DBSetLeaseCAS(path, value, CAS, int64(leaseID))
+			} else {
+				nextCAS, err = s.DBSetCAS(path, value, CAS)
+			}
+			m = map[string]interface{}{
+				"CAS": nextCAS, "err": errorString(err), "code": errorCode(err),
+			}
 		}
 
 	case "DELETE":
@@ -93,23 +138,549 @@ func (s *Server) dbHandler(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		} else {
 			path, CAS := jsonreq["path"].(string), jsonreq["CAS"].(float64)
-			nextCAS, err := s.DBDeleteCAS(path, CAS)
-			m = map[string]interface{}{"CAS": nextCAS, "err": errorString(err)}
+			var nextCAS float64
+			if CAS, err = s.ifMatchCAS(req, path, CAS); err == ErrorInvalidCAS {
+				// field is missing for `If-Match: *`.
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				break
+			} else {
+				nextCAS, err = s.DBDeleteCAS(path, CAS)
+			}
+			m = map[string]interface{}{
+				"CAS": nextCAS, "err": errorString(err), "code": errorCode(err),
+			}
+		}
+
+	case "PATCH":
+		CAS, err := parseIfMatch(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		}
+		mediatype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		switch mediatype {
+		case HttpMimeJSONPatch:
+			var ops []PatchOp
+			if err := json.NewDecoder(req.Body).Decode(&ops); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				nextCAS, err := s.DBPatchCAS(ops, CAS)
+				m = map[string]interface{}{
+					"CAS": nextCAS, "err": errorString(err), "code": errorCode(err),
+				}
+			}
+
+		case HttpMimeMergePatch:
+			var patch interface{}
+			path := req.URL.Query().Get("path")
+			if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				nextCAS, err := s.DBMergeCAS(path, patch, CAS)
+				m = map[string]interface{}{
+					"CAS": nextCAS, "err": errorString(err), "code": errorCode(err),
+				}
+			}
+
+		default:
+			msg := fmt.Sprintf("unsupported content-type %q", mediatype)
+			http.Error(w, msg, http.StatusUnsupportedMediaType)
 		}
 
 	default:
-		log.Fatalf("%v, uknown method %q\n", s.logPrefix, req.Method)
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
 	}
 
 	if m != nil {
-		if data, err := json.Marshal(&m); err != nil {
+		writeJSON(w, req, m)
+	}
+}
+
+func (s *Server) txnHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+
+	txn := &Txn{}
+	if err := json.NewDecoder(req.Body).Decode(txn); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := s.DBTxn(txn)
+	m := map[string]interface{}{
+		"succeeded": result.Succeeded, "CAS": result.CAS,
+		"err": errorString(err), "code": errorCode(err),
+	}
+	writeJSON(w, req, m)
+}
+
+func (s *Server) changesHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "GET" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	CAS, err := strconv.ParseFloat(req.URL.Query().Get("CAS"), 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	changes, err := s.GetChangesSince(CAS)
+	m := map[string]interface{}{
+		"changes": changes, "err": errorString(err), "code": errorCode(err),
+	}
+	writeJSON(w, req, m)
+}
+
+func (s *Server) compactHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	jsonreq, err := parseRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	CAS, _ := jsonreq["CAS"].(float64)
+	nextCAS, err := s.DBCompact(CAS)
+	m := map[string]interface{}{
+		"CAS": nextCAS, "err": errorString(err), "code": errorCode(err),
+	}
+	writeJSON(w, req, m)
+}
+
+// backupHandler streams a consistent backup of the dictionary.
+func (s *Server) backupHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "GET" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.Backup(w); err != nil {
+		log.Printf("%v, backup: %v\n", s.logPrefix, err)
+	}
+}
+
+// restoreHandler restores the backup in request body on a fresh cluster.
+func (s *Server) restoreHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	nextCAS, err := s.Restore(req.Body)
+	m := map[string]interface{}{
+		"CAS": nextCAS, "err": errorString(err), "code": errorCode(err),
+	}
+	writeJSON(w, req, m)
+}
+
+// membersHandler lists members of the cluster on GET, adds a member on
+// POST and removes a member on DELETE.
+func (s *Server) membersHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	var m map[string]interface{}
+	switch req.Method {
+	case "GET":
+		m = map[string]interface{}{"members": s.Members(), "err": "", "code": ""}
+
+	case "POST", "DELETE":
+		var member Member
+		if err := json.NewDecoder(req.Body).Decode(&member); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if req.Method == "POST" && member.Role == RoleLearner {
+			err = s.AddLearner(member.Name, member.ConnectionString)
+		} else if req.Method == "POST" {
+			err = s.AddMember(member.Name, member.ConnectionString)
+		} else {
+			err = s.RemoveMember(member.Name)
+		}
+		m = map[string]interface{}{"err": errorString(err), "code": errorCode(err)}
+
+	default:
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, req, m)
+}
+
+// transferHandler transfers leadership to target and stops the leader,
+// requests received by a follower are forwarded to the leader.
+func (s *Server) transferHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	jsonreq, err := parseRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, _ := jsonreq["target"].(string)
+	leader := target
+	if err = s.TransferLeadership(target); err != nil {
+		leader = s.GetLeader()[0]
+	}
+	m := map[string]interface{}{
+		"leader": leader, "err": errorString(err), "code": errorCode(err),
+	}
+	writeJSON(w, req, m)
+}
+
+// campaignHandler makes this server campaign for leadership, called by the
+// leader when transferring leadership to this server. Requests that are not
+// from the current leader are rejected, else any client could keep this
+// server triggering elections.
+func (s *Server) campaignHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	jsonreq, err := parseRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	leader, _ := jsonreq["leader"].(string)
+	if leader == "" || leader != s.raftServer.Leader() {
+		err = raft.NotLeaderError
+	} else if s.raftServer.State() == raft.Leader {
+		err = ErrorTransferFailed
+	} else {
+		s.campaign()
+	}
+	m := map[string]interface{}{"err": errorString(err), "code": errorCode(err)}
+	writeJSON(w, req, m)
+}
+
+// dictRoutes are endpoints under `/dict/`, top-level fields named after
+// them cannot be addressed by URL and shall be accessed through `/dict`.
+var dictRoutes = []string{"txn", "changes", "compact", "watch"}
+
+// resourceHandler serves fields addressed by URL, `/dict/users/0/eyeColor`
+// addresses the field at jsonpointer `/users/0/eyeColor`. Value of the
+// field is the request body for PUT and response body for GET. Expected
+// CAS is passed in If-Match header, `If-Match: *` matches the field if it
+// exists. Field's CAS is returned in ETag, along with dictionary's CAS in
+// HttpHdrNameCAS header for GET. Refer dictRoutes for fields that cannot
+// be addressed by URL.
+func (s *Server) resourceHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	path := strings.TrimPrefix(req.URL.Path, "/dict")
+	if path == "/" {
+		path = ""
+	}
+	var CAS, nextCAS float64
+	var err error
+	switch req.Method {
+	case "GET":
+		if err = s.SyncRead(req.URL.Query().Get("consistency")); err != nil {
+			break
+		}
+		var value interface{}
+		var rev Revision
+		if value, CAS, rev, err = s.DBGetRev(path); err != nil {
+			break
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		etag := formatETag(rev.Modify)
+		w.Header().Set("ETag", etag)
+		w.Header().Set(HttpHdrNameCAS, strconv.FormatUint(uint64(CAS), 10))
+		if etagMatch(req.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+
+	case "PUT":
+		var value interface{}
+		if err := json.NewDecoder(req.Body).Decode(&value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if CAS, err = s.ifMatchCAS(req, path, nullCAS); err == ErrorInvalidCAS {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		leaseID, _ := strconv.ParseInt(req.URL.Query().Get("lease"), 10, 64)
+		if leaseID != 0 {
+			nextCAS, err = s.DBSetLeaseCAS(path, value, CAS, leaseID)
 		} else {
-			w.Write(data)
+			nextCAS, err = s.DBSetCAS(path, value, CAS)
+		}
+
+	case "DELETE":
+		if CAS, err = s.ifMatchCAS(req, path, nullCAS); err == ErrorInvalidCAS {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		nextCAS, err = s.DBDeleteCAS(path, CAS)
+
+	default:
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err == nil && nextCAS != nullCAS {
+		w.Header().Set("ETag", formatETag(nextCAS))
+	}
+	m := map[string]interface{}{
+		"CAS": nextCAS, "err": errorString(err), "code": errorCode(err),
+	}
+	writeJSON(w, req, m)
+}
+
+// leaseHandler grants, keeps alive and revokes leases, the operation is
+// picked by the request's path.
+func (s *Server) leaseHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	jsonreq, err := parseRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var m map[string]interface{}
+	leaseID, _ := jsonreq["lease"].(float64)
+	switch req.URL.Path {
+	case "/lease/grant":
+		ttl, _ := jsonreq["ttl"].(float64)
+		id, err := s.GrantLease(time.Duration(ttl * float64(time.Second)))
+		m = map[string]interface{}{
+			"lease": id, "err": errorString(err), "code": errorCode(err),
+		}
+
+	case "/lease/keepalive":
+		ttl, err := s.KeepAlive(int64(leaseID))
+		m = map[string]interface{}{
+			"ttl": ttl.Seconds(), "err": errorString(err), "code": errorCode(err),
+		}
+
+	case "/lease/revoke":
+		nextCAS, err := s.Revoke(int64(leaseID))
+		m = map[string]interface{}{
+			"CAS": nextCAS, "err": errorString(err), "code": errorCode(err),
+		}
+
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	writeJSON(w, req, m)
+}
+
+// watchHandler streams changes as newline delimited JSON, until the client
+// closes the connection.
+func (s *Server) watchHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "GET" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := req.URL.Query()
+	fromCAS := nullCAS
+	if v := query.Get("CAS"); v != "" {
+		var err error
+		if fromCAS, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	// CAS is read before watching, so that a watch resumed from it does not
+	// miss any change.
+	CAS := s.db.GetCAS()
+	ch, cancel, err := s.Watch(query.Get("prefix"), fromCAS)
+	if err != nil {
+		code := errorCode(err)
+		w.WriteHeader(errorStatus(code))
+		enc.Encode(map[string]interface{}{"err": errorString(err), "code": code})
+		return
+	}
+	defer cancel()
+	w.Header().Set(HttpHdrNameCAS, strconv.FormatUint(uint64(CAS), 10))
+	flusher.Flush()
+
+	for {
+		select {
+		case change, ok := <-ch:
+			if !ok {
+				return
+			} else if err := enc.Encode(&change); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// forwardHandler wraps handler, so that requests that must be served by
+// the leader are forwarded to it when received by a follower. Writes, and
+// reads with leader or linearizable consistency, are forwarded.
+func (s *Server) forwardHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return s.forward(handler, leaderOnly)
+}
+
+// leaderHandler wraps handler, so that all requests, including reads, are
+// forwarded to the leader when received by a follower.
+func (s *Server) leaderHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return s.forward(handler, func(*http.Request) bool { return true })
+}
+
+// forward requests, for which mustForward return true, to the leader.
+func (s *Server) forward(
+	handler http.HandlerFunc, mustForward func(*http.Request) bool) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		if s.forwarding == ForwardNone || s.raftServer.State() == raft.Leader ||
+			req.Header.Get(HttpHdrNameForwarded) != "" || !mustForward(req) {
+			handler(w, req)
+			return
+		}
+		x := s.GetLeader()
+		target, err := url.Parse(x[1])
+		if x[1] == "" || err != nil { // leader unknown, fail as not leader.
+			handler(w, req)
+			return
+		}
+
+		tracef("%v, forwarding %v %q to %v\n", s.logPrefix, req.Method, req.URL, x[0])
+		w.Header().Set(HttpHdrNameLeader, x[0])
+		w.Header().Set(HttpHdrNameLeaderAddr, x[1])
+		switch s.forwarding {
+		case ForwardRedirect:
+			http.Redirect(w, req, x[1]+req.URL.RequestURI(), http.StatusTemporaryRedirect)
+		default:
+			req.Header.Set(HttpHdrNameForwarded, s.raftServer.Name())
+			httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, req)
 		}
 	}
 }
 
+// leaderOnly return true if the request shall be served by the leader.
+func leaderOnly(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD":
+		consistency := req.URL.Query().Get("consistency")
+		return consistency == ConsistencyLeader || consistency == ConsistencyLinearizable
+	}
+	return true
+}
+
 func parseRequest(req *http.Request) (jsonreq map[string]interface{}, err error) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -120,6 +691,82 @@ func parseRequest(req *http.Request) (jsonreq map[string]interface{}, err error)
 	return jsonreq, err
 }
 
+// formatETag for CAS, as a quoted entity-tag.
+func formatETag(CAS float64) string {
+	return `"` + strconv.FormatUint(uint64(CAS), 10) + `"`
+}
+
+// parseETag return the CAS in entity-tag, quotes and weak prefix are
+// optional.
+func parseETag(etag string) (float64, error) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	return strconv.ParseFloat(strings.Trim(etag, `"`), 64)
+}
+
+// parseIfMatch return the CAS specified by If-Match header, nullCAS if the
+// header is missing or is `*`, which matches the whole dictionary.
+func parseIfMatch(req *http.Request) (CAS float64, err error) {
+	switch etag := req.Header.Get("If-Match"); etag {
+	case "", "*":
+		return nullCAS, nil
+	default:
+		return parseETag(etag)
+	}
+}
+
+// ifMatchCAS return the CAS specified by If-Match header for field at
+// path, if present, otherwise CAS. `If-Match: *` matches the field's
+// current revision, and fails with ErrorInvalidCAS if field is missing.
+func (s *Server) ifMatchCAS(req *http.Request, path string, CAS float64) (float64, error) {
+	switch req.Header.Get("If-Match") {
+	case "":
+		return CAS, nil
+	case "*":
+		_, _, rev, err := s.DBGetRev(path)
+		if err != nil {
+			s.countConflict(ErrorInvalidCAS)
+			return nullCAS, ErrorInvalidCAS
+		}
+		return rev.Modify, nil
+	}
+	return parseIfMatch(req)
+}
+
+// etagMatch return true if etag is listed in If-None-Match header.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, x := range strings.Split(header, ",") {
+		x = strings.TrimPrefix(strings.TrimSpace(x), "W/")
+		if x == "*" || strings.Trim(x, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON response, with HTTP status picked by the error code in
+// response. CAS mismatch for requests with If-Match header fail with
+// 412 Precondition Failed.
+func writeJSON(w http.ResponseWriter, req *http.Request, m map[string]interface{}) {
+	data, err := json.Marshal(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code, _ := m["code"].(string)
+	if mw, ok := w.(*metricsWriter); ok {
+		mw.code = code
+	}
+	status := errorStatus(code)
+	if code == ErrorCodeCASMismatch && req.Header.Get("If-Match") != "" {
+		status = http.StatusPreconditionFailed
+	}
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
 func errorString(err error) string {
 	if err == nil {
 		return ""
@@ -0,0 +1,273 @@
+package failsafe
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goraft/raft"
+)
+
+func TestLeaderOnly(t *testing.T) {
+	testcases := []struct {
+		method, uri string
+		ref         bool
+	}{
+		{"GET", "/dict", false},
+		{"GET", "/dict?consistency=stale", false},
+		{"GET", "/dict?consistency=leader", true},
+		{"GET", "/dict?consistency=linearizable", true},
+		{"HEAD", "/dict", false},
+		{"PUT", "/dict", true},
+		{"DELETE", "/dict", true},
+		{"PATCH", "/dict", true},
+		{"POST", "/dict/txn", true},
+	}
+	for _, tcase := range testcases {
+		req, err := http.NewRequest(tcase.method, servAddr+tcase.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if leaderOnly(req) != tcase.ref {
+			t.Errorf("expected %v for %v %v", tcase.ref, tcase.method, tcase.uri)
+		}
+	}
+}
+
+func TestForwardHandler(t *testing.T) {
+	var forwarded string
+	leader := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			forwarded = req.Header.Get(HttpHdrNameForwarded)
+			body, _ := ioutil.ReadAll(req.Body)
+			w.Write([]byte("leader " + req.Method + " " + req.URL.RequestURI() + " " + string(body)))
+		}))
+	defer leader.Close()
+
+	rs := &testRaftServer{
+		name: "n1", leader: "n2", state: raft.Follower,
+		peers: map[string]*raft.Peer{"n2": {Name: "n2", ConnectionString: leader.URL}},
+	}
+	s := &Server{raftServer: rs}
+	follower := httptest.NewServer(s.forwardHandler(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("local"))
+		}))
+	defer follower.Close()
+
+	noRedirect := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(httpc *http.Client, method, uri string) (*http.Response, string) {
+		req, err := http.NewRequest(method, follower.URL+uri, strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := httpc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	// follower proxies writes to the leader.
+	s.forwarding = ForwardProxy
+	resp, body := do(http.DefaultClient, "PUT", "/dict?x=1")
+	if body != "leader PUT /dict?x=1 body" {
+		t.Fatal("unexpected response", body)
+	} else if forwarded != "n1" {
+		t.Fatal("expected forwarded header", forwarded)
+	} else if resp.Header.Get(HttpHdrNameLeader) != "n2" {
+		t.Fatal("expected leader header", resp.Header)
+	}
+	uri := "/dict?consistency=leader"
+	if _, body := do(http.DefaultClient, "GET", uri); body != "leader GET "+uri+" body" {
+		t.Fatal("unexpected response", body)
+	} else if _, body := do(http.DefaultClient, "GET", "/dict"); body != "local" {
+		t.Fatal("expected stale read to be served locally", body)
+	}
+
+	// follower redirects writes to the leader.
+	s.forwarding = ForwardRedirect
+	forwarded = ""
+	resp, _ = do(noRedirect, "DELETE", "/dict?x=1")
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatal("expected redirect", resp.StatusCode)
+	} else if loc := resp.Header.Get("Location"); loc != leader.URL+"/dict?x=1" {
+		t.Fatal("unexpected location", loc)
+	}
+	if _, body := do(http.DefaultClient, "PUT", "/dict"); body != "leader PUT /dict body" {
+		t.Fatal("unexpected response", body)
+	} else if forwarded != "" {
+		t.Fatal("unexpected forwarded header", forwarded)
+	}
+
+	// follower serves requests when leader is unknown or forwarding is
+	// disabled.
+	rs.leader = ""
+	if _, body := do(http.DefaultClient, "PUT", "/dict"); body != "local" {
+		t.Fatal("unexpected response", body)
+	}
+	rs.leader, s.forwarding = "n2", ForwardNone
+	if _, body := do(http.DefaultClient, "PUT", "/dict"); body != "local" {
+		t.Fatal("unexpected response", body)
+	}
+
+	// reads are forwarded as well, for handlers served only by leader.
+	s.forwarding = ForwardProxy
+	members := httptest.NewServer(s.leaderHandler(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("local"))
+		}))
+	defer members.Close()
+	resp, err := http.Get(members.URL + "/admin/members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if data, _ := ioutil.ReadAll(resp.Body); string(data) != "leader GET /admin/members " {
+		t.Fatal("unexpected response", string(data))
+	}
+}
+
+func TestResourceHandler(t *testing.T) {
+	sd, err := NewSafeDict(smallJSON, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: sd}
+
+	// GET field addressed by URL.
+	req := httptest.NewRequest("GET", "/dict/eyeColor", nil)
+	w := httptest.NewRecorder()
+	s.resourceHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status", w.Code)
+	} else if body := w.Body.String(); body != `"brown"` {
+		t.Fatal("unexpected body", body)
+	} else if etag := w.Header().Get("ETag"); etag != `"1"` {
+		t.Fatal("unexpected etag", etag)
+	}
+
+	// missing field.
+	req = httptest.NewRequest("GET", "/dict/friends/0/missing", nil)
+	w = httptest.NewRecorder()
+	s.resourceHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatal("unexpected status", w.Code)
+	}
+
+	// method not allowed.
+	req = httptest.NewRequest("POST", "/dict/eyeColor", nil)
+	w = httptest.NewRecorder()
+	s.resourceHandler(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatal("unexpected status", w.Code)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	sd, err := NewSafeDict(smallJSON, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: sd, metrics: newMetrics()}
+
+	// body based GET.
+	req := httptest.NewRequest("GET", "/dict", strings.NewReader(`{"path": "/eyeColor"}`))
+	req.Header.Set("If-None-Match", `"1"`)
+	w := httptest.NewRecorder()
+	s.dbHandler(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Fatal("unexpected status", w.Code)
+	}
+
+	// resource GET.
+	req = httptest.NewRequest("GET", "/dict/eyeColor", nil)
+	req.Header.Set("If-None-Match", `"2", W/"1"`)
+	w = httptest.NewRecorder()
+	s.resourceHandler(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Fatal("unexpected status", w.Code)
+	}
+	req.Header.Set("If-None-Match", `"2"`)
+	w = httptest.NewRecorder()
+	s.resourceHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status", w.Code)
+	}
+
+	if etag := w.Header().Get("ETag"); etag != `"1"` {
+		t.Fatal("expected quoted ETag", etag)
+	} else if CAS := w.Header().Get(HttpHdrNameCAS); CAS != "1" {
+		t.Fatal("unexpected CAS", CAS)
+	}
+
+	// If-Match: * matches existing fields.
+	req = httptest.NewRequest("PUT", "/dict/eyeColor", strings.NewReader(`"blue"`))
+	req.Header.Set("If-Match", "*")
+	if CAS, err := s.ifMatchCAS(req, "/eyeColor", nullCAS); err != nil {
+		t.Fatal(err)
+	} else if CAS != 1 {
+		t.Fatal("unexpected CAS", CAS)
+	}
+	if _, err := s.ifMatchCAS(req, "/missing", nullCAS); err != ErrorInvalidCAS {
+		t.Fatal("expected ErrorInvalidCAS", err)
+	}
+	req.Header.Set("If-Match", `"1"`)
+	if CAS, err := s.ifMatchCAS(req, "/missing", nullCAS); err != nil {
+		t.Fatal(err)
+	} else if CAS != 1 {
+		t.Fatal("unexpected CAS", CAS)
+	}
+
+	// CAS mismatch with If-Match.
+	m := map[string]interface{}{
+		"err": ErrorInvalidCAS.Error(), "code": ErrorCodeCASMismatch,
+	}
+	req = httptest.NewRequest("PUT", "/dict/eyeColor", nil)
+	w = httptest.NewRecorder()
+	writeJSON(w, req, m)
+	if w.Code != http.StatusConflict {
+		t.Fatal("unexpected status", w.Code)
+	}
+	req.Header.Set("If-Match", "1")
+	w = httptest.NewRecorder()
+	writeJSON(w, req, m)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatal("unexpected status", w.Code)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	sd, err := NewSafeDict(smallJSON, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: sd}
+
+	handlers := map[string]http.HandlerFunc{
+		"/dict":                  s.dbHandler,
+		"/dict/eyeColor":         s.resourceHandler,
+		"/dict/txn":              s.txnHandler,
+		"/admin/backup":          s.backupHandler,
+		"/admin/restore":         s.restoreHandler,
+		"/admin/members":         s.membersHandler,
+		"/admin/promote":         s.promoteHandler,
+		"/admin/transfer-leader": s.transferHandler,
+		"/admin/campaign":        s.campaignHandler,
+	}
+	for uri, handler := range handlers {
+		req := httptest.NewRequest("OPTIONS", uri, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected %v for %v, got %v", http.StatusMethodNotAllowed, uri, w.Code)
+		}
+	}
+}
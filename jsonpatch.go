@@ -0,0 +1,329 @@
+// RFC 6902 JSON Patch and RFC 7386 JSON Merge Patch, applied on JSON
+// decoded data-structure.
+
+package failsafe
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// PatchOp is a single operation in RFC 6902 JSON Patch document. Op can be
+// one of "add", "remove", "replace", "move", "copy" or "test".
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// applyPatch applies operations, in order, on doc and returns the patched
+// document. doc is not modified, hence the patch is applied atomically,
+// refer updateAt.
+func applyPatch(doc interface{}, ops []PatchOp) (interface{}, error) {
+	var value interface{}
+	var ok bool
+	var err error
+
+	for _, op := range ops {
+		switch op.Op {
+		case "add":
+			doc, err = patchAdd(doc, op.Path, op.Value)
+
+		case "remove":
+			doc, _, err = patchRemove(doc, op.Path)
+
+		case "replace":
+			if doc, _, err = patchRemove(doc, op.Path); err == nil {
+				doc, err = patchAdd(doc, op.Path, op.Value)
+			}
+
+		case "move":
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, ErrorInvalidPath
+			}
+			if doc, value, err = patchRemove(doc, op.From); err == nil {
+				doc, err = patchAdd(doc, op.Path, value)
+			}
+
+		case "copy":
+			if value, ok = patchGet(doc, op.From); !ok {
+				return nil, ErrorInvalidPath
+			}
+			doc, err = patchAdd(doc, op.Path, copyJSON(value))
+
+		case "test":
+			if value, ok = patchGet(doc, op.Path); !ok {
+				return nil, ErrorInvalidPath
+			} else if !jsonEqual(value, op.Value) {
+				return nil, ErrorPatchTest
+			}
+
+		default:
+			return nil, ErrorInvalidPatch
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// patchGet value located by path.
+func patchGet(doc interface{}, path string) (value interface{}, ok bool) {
+	if path == "" {
+		return doc, true
+	}
+	value = doc
+	for _, part := range parseJSONPointer(path) {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			if value, ok = container[part]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := arrayIndex(part, len(container))
+			if err != nil {
+				return nil, false
+			}
+			value = container[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// patchAdd value at path, member of an object is added or replaced while an
+// array element is inserted at the index, "-" appends to the array.
+func patchAdd(doc interface{}, path string, value interface{}) (interface{}, error) {
+	if path == "" {
+		return value, nil
+	}
+	parts := parseJSONPointer(path)
+	return updateAt(doc, parts, func(node interface{}, key string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			container[key] = value
+			return container, nil
+
+		case []interface{}:
+			if key == "-" {
+				return append(container, value), nil
+			}
+			i, err := arrayIndex(key, len(container)+1)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[i+1:], container[i:])
+			container[i] = value
+			return container, nil
+		}
+		return nil, ErrorInvalidPath
+	})
+}
+
+// patchReplace value at path, member of an object is added or replaced
+// while an array element is replaced.
+func patchReplace(doc interface{}, path string, value interface{}) (interface{}, error) {
+	if path == "" {
+		return value, nil
+	}
+	parts := parseJSONPointer(path)
+	return updateAt(doc, parts, func(node interface{}, key string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			container[key] = value
+			return container, nil
+
+		case []interface{}:
+			i, err := arrayIndex(key, len(container))
+			if err != nil {
+				return nil, err
+			}
+			container[i] = value
+			return container, nil
+		}
+		return nil, ErrorInvalidPath
+	})
+}
+
+// patchRemove value at path, which must exist, and return the removed value.
+func patchRemove(doc interface{}, path string) (interface{}, interface{}, error) {
+	var old interface{}
+
+	if path == "" {
+		return nil, doc, nil
+	}
+	parts := parseJSONPointer(path)
+	doc, err := updateAt(doc, parts, func(node interface{}, key string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			value, ok := container[key]
+			if !ok {
+				return nil, ErrorInvalidPath
+			}
+			old = value
+			delete(container, key)
+			return container, nil
+
+		case []interface{}:
+			i, err := arrayIndex(key, len(container))
+			if err != nil {
+				return nil, err
+			}
+			old = container[i]
+			return append(container[:i], container[i+1:]...), nil
+		}
+		return nil, ErrorInvalidPath
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, old, nil
+}
+
+// updateAt walks down node along parts and calls fn with the container of
+// the last segment. Since arrays can grow or shrink, container returned by
+// fn is stored back into its parent. Containers along the path are copied
+// before they are updated, so that node is never modified in place and
+// values that are not updated are shared with the updated document.
+func updateAt(
+	node interface{}, parts []string,
+	fn func(interface{}, string) (interface{}, error)) (interface{}, error) {
+
+	node = shallowCopy(node)
+	if len(parts) == 1 {
+		return fn(node, parts[0])
+	}
+	switch container := node.(type) {
+	case map[string]interface{}:
+		child, ok := container[parts[0]]
+		if !ok {
+			return nil, ErrorInvalidPath
+		}
+		child, err := updateAt(child, parts[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		container[parts[0]] = child
+		return container, nil
+
+	case []interface{}:
+		i, err := arrayIndex(parts[0], len(container))
+		if err != nil {
+			return nil, err
+		}
+		child, err := updateAt(container[i], parts[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		container[i] = child
+		return container, nil
+	}
+	return nil, ErrorInvalidPath
+}
+
+// arrayIndex parses jsonpointer segment as array index, which must be less
+// than n. Leading zeros and signs are not allowed.
+func arrayIndex(part string, n int) (int, error) {
+	i, err := strconv.Atoi(part)
+	if err != nil || i < 0 || i >= n || strconv.Itoa(i) != part {
+		return 0, ErrorInvalidPath
+	}
+	return i, nil
+}
+
+// mergePatch applies RFC 7386 JSON Merge Patch on target and returns the
+// merged value, null in patch deletes the member from target. target is
+// not modified, objects are copied before they are merged.
+func mergePatch(target, patch interface{}) interface{} {
+	patchm, ok := patch.(map[string]interface{})
+	if !ok {
+		return copyJSON(patch)
+	}
+	targetm, ok := target.(map[string]interface{})
+	if ok {
+		targetm = shallowCopy(targetm).(map[string]interface{})
+	} else {
+		targetm = make(map[string]interface{})
+	}
+	for key, value := range patchm {
+		if value == nil {
+			delete(targetm, key)
+		} else {
+			targetm[key] = mergePatch(targetm[key], value)
+		}
+	}
+	return targetm
+}
+
+// mergePaths return paths of fields under path that are replaced or
+// removed when patch is merged with target.
+func mergePaths(path string, target, patch interface{}) []string {
+	patchm, ok := patch.(map[string]interface{})
+	targetm, tok := target.(map[string]interface{})
+	if !ok || !tok {
+		return []string{path}
+	}
+	paths := make([]string, 0, len(patchm))
+	for key, value := range patchm {
+		childpath := path + encodeJSONPointer([]string{key})
+		if _, ok := targetm[key]; ok || value != nil {
+			paths = append(paths, mergePaths(childpath, targetm[key], value)...)
+		}
+	}
+	return paths
+}
+
+// shallowCopy of a container, its values are shared with the original.
+func shallowCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v)+1)
+		for key, x := range v {
+			m[key] = x
+		}
+		return m
+
+	case []interface{}:
+		return append(make([]interface{}, 0, len(v)+1), v...)
+	}
+	return value
+}
+
+// copyJSON makes a deep copy of JSON decoded value.
+func copyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, x := range v {
+			m[key] = copyJSON(x)
+		}
+		return m
+
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, x := range v {
+			arr[i] = copyJSON(x)
+		}
+		return arr
+	}
+	return value
+}
+
+// jsonEqual compares two values by their JSON encoding, so that numbers are
+// equal irrespective of their native type.
+func jsonEqual(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(x) == string(y)
+}
@@ -0,0 +1,200 @@
+package failsafe
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	testcases := [][3]string{
+		{`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":"qux"}]`,
+			`{"foo":["bar","qux"]}`},
+		{`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`,
+			`{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":{"bar":[1,2]}}`,
+			`[{"op":"copy","from":"/foo/bar","path":"/baz"},
+			  {"op":"add","path":"/baz/-","value":3}]`,
+			`{"baz":[1,2,3],"foo":{"bar":[1,2]}}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},
+			  {"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`,
+			`[{"op":"add","path":"","value":{"baz":"qux"}}]`,
+			`{"baz":"qux"}`},
+	}
+	for _, tcase := range testcases {
+		var doc, ref interface{}
+		var ops []PatchOp
+		json.Unmarshal([]byte(tcase[0]), &doc)
+		if err := json.Unmarshal([]byte(tcase[1]), &ops); err != nil {
+			t.Fatal(err)
+		}
+		json.Unmarshal([]byte(tcase[2]), &ref)
+		if doc, err := applyPatch(doc, ops); err != nil {
+			t.Fatalf("%v: %v", tcase[1], err)
+		} else if reflect.DeepEqual(doc, ref) == false {
+			t.Fatalf("%v: expected %v, got %v", tcase[1], ref, doc)
+		}
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	testcases := []struct {
+		doc, patch string
+		err        error
+	}{
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, ErrorInvalidPath},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":1}]`,
+			ErrorInvalidPath},
+		{`{"foo":[1]}`, `[{"op":"add","path":"/foo/2","value":1}]`,
+			ErrorInvalidPath},
+		{`{"foo":[1]}`, `[{"op":"replace","path":"/foo/01","value":1}]`,
+			ErrorInvalidPath},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/x"}]`,
+			ErrorInvalidPath},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`,
+			ErrorPatchTest},
+		{`{"baz":"qux"}`, `[{"op":"frob","path":"/baz"}]`, ErrorInvalidPatch},
+	}
+	for _, tcase := range testcases {
+		var doc interface{}
+		var ops []PatchOp
+		json.Unmarshal([]byte(tcase.doc), &doc)
+		json.Unmarshal([]byte(tcase.patch), &ops)
+		if _, err := applyPatch(doc, ops); err != tcase.err {
+			t.Fatalf("%v: expected %v, got %v", tcase.patch, tcase.err, err)
+		}
+	}
+}
+
+func TestPatchSafeDict(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": 10, "b": [1, 2]}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1 := copyJSON(sd.m)
+
+	// a failing operation shall leave the dictionary untouched.
+	ops := []PatchOp{
+		{Op: "replace", Path: "/a", Value: float64(20)},
+		{Op: "remove", Path: "/b/0"},
+		{Op: "test", Path: "/a", Value: float64(10)},
+	}
+	if _, err := sd.Patch(ops, sd.GetCAS()); err != ErrorPatchTest {
+		t.Fatal("expected ErrorPatchTest", err)
+	} else if reflect.DeepEqual(sd.m, m1) == false {
+		t.Fatal("failed patch is not atomic", sd.m)
+	} else if sd.GetCAS() != 1 {
+		t.Fatal("failed patch shall not increment CAS")
+	}
+
+	if _, err := sd.Patch(ops[:2], sd.GetCAS()+1); err != ErrorInvalidCAS {
+		t.Fatal("expected ErrorInvalidCAS", err)
+	}
+	if cas, err := sd.Patch(ops[:2], sd.GetCAS()); err != nil {
+		t.Fatal(err)
+	} else if cas != 2 {
+		t.Fatal("expected CAS as 2", cas)
+	}
+	ref := map[string]interface{}{
+		"a": float64(20), "b": []interface{}{float64(2)},
+	}
+	if val, _, err := sd.Get(""); err != nil {
+		t.Fatal(err)
+	} else if reflect.DeepEqual(val, ref) == false {
+		t.Fatal("failed patch", val)
+	}
+
+	// removing the root leaves an empty dictionary.
+	ops = []PatchOp{{Op: "remove", Path: ""}}
+	if _, err := sd.Patch(ops, nullCAS); err != nil {
+		t.Fatal(err)
+	} else if val, _, err := sd.Get(""); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(val, map[string]interface{}{}) {
+		t.Fatal("expected empty dictionary", val)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	testcases := [][3]string{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tcase := range testcases {
+		var target, patch, ref interface{}
+		json.Unmarshal([]byte(tcase[0]), &target)
+		json.Unmarshal([]byte(tcase[1]), &patch)
+		json.Unmarshal([]byte(tcase[2]), &ref)
+		if value := mergePatch(target, patch); !reflect.DeepEqual(value, ref) {
+			t.Fatalf("%v: expected %v, got %v", tcase[1], ref, value)
+		}
+	}
+}
+
+func TestMergeSafeDict(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": {"b": 1, "c": 2}}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := map[string]interface{}{"c": nil, "d": float64(3)}
+	if cas, err := sd.Merge("/a", patch, sd.GetCAS()); err != nil {
+		t.Fatal(err)
+	} else if cas != 2 {
+		t.Fatal("expected CAS as 2", cas)
+	}
+	ref := map[string]interface{}{"b": float64(1), "d": float64(3)}
+	if val, _, err := sd.Get("/a"); err != nil {
+		t.Fatal(err)
+	} else if reflect.DeepEqual(val, ref) == false {
+		t.Fatal("failed merge", val)
+	}
+
+	// merge on missing field creates it.
+	if _, err := sd.Merge("/x", patch, nullCAS); err != nil {
+		t.Fatal(err)
+	} else if val, _, _ := sd.Get("/x"); reflect.DeepEqual(val, patch) {
+		t.Fatal("failed merge, null shall not be created", val)
+	} else if val.(map[string]interface{})["d"] != float64(3) {
+		t.Fatal("failed merge", val)
+	}
+	if _, err := sd.Merge("/y/z", patch, nullCAS); err != ErrorInvalidPath {
+		t.Fatal("expected ErrorInvalidPath", err)
+	}
+	if _, err := sd.Merge("", "value", nullCAS); err != ErrorInvalidType {
+		t.Fatal("expected ErrorInvalidType", err)
+	}
+}
@@ -0,0 +1,379 @@
+// Learner nodes.
+//
+// goraft has no notion of non-voting members, every peer votes and counts
+// towards the quorum. Learners are therefore not raft peers, they
+// bootstrap from leader's backup and replicate the dictionary by watching
+// its changes. Learners serve stale reads from the local dictionary and
+// forward writes, and stronger reads, to the leader. Changes that cannot
+// be replicated faithfully make the learner bootstrap again, backup is
+// decoded aside and swapped in, so that reads are served meanwhile.
+//
+// Learners register with the leader periodically, hence they are listed
+// by Members() on the leader. A learner that has caught up with the
+// leader's CAS can be promoted to a voter by Promote(), which joins the
+// raft cluster. Replicated dictionary is served until raft replaces it,
+// either by recovering leader's snapshot or, if leader's log is replayed
+// from the start, by applying the log on an empty dictionary.
+
+package failsafe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goraft/raft"
+)
+
+// ErrorNotLearner when promoting a server that is not a learner.
+var ErrorNotLearner = fmt.Errorf("failsafe.errorNotLearner")
+
+// ErrorLearnerLeader when a learner is installed without a leader.
+var ErrorLearnerLeader = fmt.Errorf("failsafe.errorLearnerLeader")
+
+// ErrorLearnerBehind when promoting a learner that has not caught up with
+// the leader.
+var ErrorLearnerBehind = fmt.Errorf("failsafe.errorLearnerBehind")
+
+// SetLearner to install this server as a non-voting learner, shall be
+// called before Install.
+func (s *Server) SetLearner(learner bool) {
+	s.learnerMu.Lock()
+	defer s.learnerMu.Unlock()
+	s.learner = learner
+}
+
+func (s *Server) isLearner() bool {
+	s.learnerMu.Lock()
+	defer s.learnerMu.Unlock()
+	return s.learner
+}
+
+// installLearner starts replicating from the leader, raft-server is
+// started only when the learner is promoted.
+func (s *Server) installLearner(leader string) error {
+	if leader == "" {
+		return ErrorLearnerLeader
+	}
+	s.learnerMu.Lock()
+	s.leaderInfo = [2]string{"", "http://" + leader}
+	s.learnerMu.Unlock()
+
+	s.installHandlers()
+	s.startLearning()
+	return nil
+}
+
+func (s *Server) startLearning() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.learnerMu.Lock()
+	s.learnCancel, s.learnDone = cancel, done
+	s.learnerMu.Unlock()
+	go s.learn(ctx, done)
+}
+
+// stopLearning and wait for the learner to stop replicating.
+func (s *Server) stopLearning() {
+	s.learnerMu.Lock()
+	cancel, done := s.learnCancel, s.learnDone
+	s.learnerMu.Unlock()
+	cancel()
+	<-done
+}
+
+// learn bootstraps the dictionary from leader's backup and replicates
+// changes from there on, until ctx is cancelled, done is closed on return.
+func (s *Server) learn(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	s.learnerMu.Lock()
+	cl := newCluster([]string{s.leaderInfo[1]})
+	s.learnerMu.Unlock()
+	go s.registerLearner(ctx, cl)
+
+	for {
+		if CAS, err := s.bootstrap(ctx, cl); err != nil {
+			log.Printf("%v, bootstrapping learner: %v\n", s.logPrefix, err)
+		} else {
+			s.replicate(ctx, cl, CAS)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(learnerRetryInterval):
+		}
+	}
+}
+
+// bootstrap the dictionary from leader's backup, return the CAS of
+// backup.
+func (s *Server) bootstrap(ctx context.Context, cl *cluster) (float64, error) {
+	_, data, err := cl.do(ctx, true, "GET", "/admin/backup", "application/json", nil, nil)
+	if err != nil {
+		return nullCAS, err
+	}
+	var backup Backup
+	restored := &SafeDict{}
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nullCAS, err
+	} else if err := json.Unmarshal(backup.Dict, restored); err != nil {
+		return nullCAS, err
+	}
+	s.db.swap(restored)
+	s.setBootstrapped(true)
+	tracef("%v, learner bootstrapped upto CAS %v\n", s.logPrefix, backup.CAS)
+	return backup.CAS, nil
+}
+
+func (s *Server) setBootstrapped(bootstrapped bool) {
+	s.learnerMu.Lock()
+	defer s.learnerMu.Unlock()
+	s.bootstrapped = bootstrapped
+}
+
+// replicate changes after CAS until ctx is cancelled, or watch is lost,
+// or a change cannot be replicated.
+func (s *Server) replicate(ctx context.Context, cl *cluster, CAS float64) {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	changes := make(chan Change, watchChanSize)
+	go cl.watch("", uint64(CAS), changes, wctx.Done())
+	for change := range changes {
+		if wctx.Err() != nil {
+			continue // drain until watch is closed.
+		} else if !s.db.replicate(change) {
+			tracef("%v, learner cannot replicate %v %q\n",
+				s.logPrefix, change.Op, change.Path)
+			cancel()
+		}
+	}
+}
+
+// registerLearner with the leader periodically and remember the leader.
+func (s *Server) registerLearner(ctx context.Context, cl *cluster) {
+	member := Member{
+		Name:             s.name,
+		ConnectionString: s.connectionString(),
+		Role:             RoleLearner,
+	}
+	for {
+		htresp, _, err := cl.do(ctx, true, "HEAD", "/dict", "", nil, nil)
+		if err == nil {
+			s.learnerMu.Lock()
+			s.leaderInfo = [2]string{
+				htresp.Header.Get(HttpHdrNameLeader),
+				htresp.Header.Get(HttpHdrNameLeaderAddr),
+			}
+			s.learnerMu.Unlock()
+			err = cl.changeMember(ctx, "POST", member)
+		}
+		if err != nil && ctx.Err() == nil {
+			debugf("%v, registering learner: %v\n", s.logPrefix, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(learnerHeartbeat):
+		}
+	}
+}
+
+// AddLearner registers a learner with the leader, learners register
+// themselves periodically and are forgotten if they fail to do so.
+func (s *Server) AddLearner(name, connectionString string) error {
+	if s.raftServer.State() != raft.Leader {
+		return raft.NotLeaderError
+	}
+	s.learnerMu.Lock()
+	defer s.learnerMu.Unlock()
+	s.learners[name] = Member{
+		Name:             name,
+		ConnectionString: connectionString,
+		Role:             RoleLearner,
+		LastContact:      time.Now(),
+	}
+	return nil
+}
+
+// getLearners registered with this server, expired learners and learners
+// that have since joined as peers are forgotten.
+func (s *Server) getLearners() []Member {
+	s.learnerMu.Lock()
+	defer s.learnerMu.Unlock()
+
+	peers := s.raftServer.Peers()
+	members := make([]Member, 0, len(s.learners))
+	for name, member := range s.learners {
+		_, ok := peers[name]
+		if ok || time.Since(member.LastContact) > learnerExpiry {
+			delete(s.learners, name)
+			continue
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// removeLearner return true if name was a registered learner.
+func (s *Server) removeLearner(name string) bool {
+	s.learnerMu.Lock()
+	defer s.learnerMu.Unlock()
+
+	_, ok := s.learners[name]
+	delete(s.learners, name)
+	return ok
+}
+
+// Promote this learner to a voting member, learner shall have caught up
+// with leader's CAS. Learner stops replicating, starts the raft-server and
+// joins the leader. If joining fails, learner resumes replicating.
+func (s *Server) Promote() error {
+	s.promoteMu.Lock()
+	defer s.promoteMu.Unlock()
+
+	if !s.isLearner() {
+		return ErrorNotLearner
+	}
+	s.learnerMu.Lock()
+	leaderAddr := s.leaderInfo[1]
+	s.learnerMu.Unlock()
+
+	cl := newCluster([]string{leaderAddr})
+	htresp, _, err := cl.do(context.Background(), true, "HEAD", "/dict", "", nil, nil)
+	if err != nil {
+		return err
+	}
+	leaderCAS, err := parseETag(htresp.Header.Get("ETag"))
+	if err != nil {
+		return err
+	} else if s.db.GetCAS() < leaderCAS {
+		return ErrorLearnerBehind
+	}
+
+	s.stopLearning()
+	s.db.setPromoted(true)
+	leader := strings.TrimPrefix(leaderAddr, "http://")
+	err = s.raftServer.Start()
+	if err == nil && !s.raftServer.IsLogEmpty() {
+		err = ErrorExistingLog
+	}
+	if err == nil {
+		err = s.selfJoin(leader)
+	}
+	if err != nil {
+		if s.raftServer.Running() {
+			s.raftServer.Stop()
+		}
+		s.db.setPromoted(false)
+		s.startLearning()
+		return err
+	}
+
+	s.learnerMu.Lock()
+	s.learner = false
+	s.learnerMu.Unlock()
+	s.AddEventListeners()
+	s.startBackground()
+	return nil
+}
+
+// promoteHandler promotes this learner to a voting member.
+func (s *Server) promoteHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	err := s.Promote()
+	m := map[string]interface{}{"err": errorString(err), "code": errorCode(err)}
+	writeJSON(w, req, m)
+}
+
+// swap dictionary's content with restored, watchers are dropped.
+func (sd *SafeDict) swap(restored *SafeDict) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.load(restored)
+}
+
+// setPromoted marks the dictionary of a promoted learner, that is served
+// until raft replaces it.
+func (sd *SafeDict) setPromoted(promoted bool) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.promoted = promoted
+}
+
+// discardPromoted dictionary before raft applies a command on it, that is,
+// when raft replays the log from the start instead of recovering a
+// snapshot.
+func (sd *SafeDict) discardPromoted() {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if sd.promoted {
+		m := make(map[string]interface{})
+		sd.load(&SafeDict{m: m, CAS: 1, revs: newRevisions(m, 1)})
+	}
+}
+
+// replicate a change received from the leader, return false if the
+// change cannot be replicated and dictionary shall be bootstrapped again.
+// Changes from the same write share the same CAS.
+func (sd *SafeDict) replicate(change Change) bool {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if change.CAS < sd.CAS {
+		return true // already replicated.
+	}
+	path, oldm := change.Path, sd.m
+	switch change.Op {
+	case "set", "merge", "patch":
+		doc, err := patchReplace(sd.m, path, change.NewValue)
+		if err != nil {
+			return false
+		}
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		sd.m = m
+
+	case "delete":
+		if path == "" {
+			sd.m = make(map[string]interface{})
+		} else if _, ok := sd.lookup(path); ok {
+			doc, err := deleteField(sd.m, path)
+			if err != nil {
+				return false
+			}
+			sd.m = doc.(map[string]interface{})
+		}
+
+	default:
+		return false
+	}
+
+	sd.CAS = change.CAS
+	sd.touch(oldm, []string{path}, sd.CAS)
+	changes := []Change{change}
+	sd.history.add(changes, sd.CAS)
+	sd.notify(changes)
+	return true
+}
@@ -0,0 +1,121 @@
+package failsafe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestReplicateSafeDict(t *testing.T) {
+	leader, err := NewSafeDict([]byte(`{"nodes": {"n1": "up"}, "x": [1, 2]}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := leader.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	learner, err := NewSafeDict(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := learner.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	learner.SetVersionWindow(100)
+	ch, cancel, err := leader.Watch("", nullCAS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	leader.Set("/nodes/n2", "up", nullCAS)
+	leader.Set("/x/0", nil, nullCAS)
+	leader.Delete("/nodes/n1", nullCAS)
+	leader.Merge("/nodes", map[string]interface{}{"n3": "down"}, nullCAS)
+	leader.Txn(&Txn{
+		Compare: []TxnCompare{CompareMissing("/locks")},
+		Then:    []TxnOp{OpSet("/locks", map[string]interface{}{}), OpDelete("/x")},
+	})
+	for len(ch) > 0 {
+		if !learner.replicate(<-ch) {
+			t.Fatal("failed to replicate")
+		}
+	}
+	if learner.GetCAS() != leader.GetCAS() {
+		t.Fatalf("expected %v, got %v", leader.GetCAS(), learner.GetCAS())
+	} else if !reflect.DeepEqual(learner.m, leader.m) {
+		t.Fatalf("expected %v, got %v", leader.m, learner.m)
+	} else if !reflect.DeepEqual(learner.revs, leader.revs) {
+		t.Fatalf("expected %v, got %v", leader.revs, learner.revs)
+	}
+	// replicated changes are remembered for GetAt.
+	if value, err := learner.GetAt("/nodes/n1", 2); err != nil {
+		t.Fatal(err)
+	} else if value != "up" {
+		t.Fatal("unexpected value", value)
+	}
+
+	// JSON Patch is replicated from the values of fields it touched.
+	ops := []PatchOp{
+		{Op: "add", Path: "/y", Value: []interface{}{1, 2}},
+		{Op: "remove", Path: "/y/0"},
+		{Op: "add", Path: "/y/-", Value: 3},
+		{Op: "move", From: "/nodes/n2", Path: "/locks/n2"},
+		{Op: "replace", Path: "/locks/n2", Value: nil},
+	}
+	if _, err := leader.Patch(ops, nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	for len(ch) > 0 {
+		if !learner.replicate(<-ch) {
+			t.Fatal("failed to replicate patch")
+		}
+	}
+	if learner.GetCAS() != leader.GetCAS() {
+		t.Fatalf("expected %v, got %v", leader.GetCAS(), learner.GetCAS())
+	} else if !reflect.DeepEqual(learner.m, leader.m) {
+		t.Fatalf("expected %v, got %v", leader.m, learner.m)
+	} else if !reflect.DeepEqual(learner.revs, leader.revs) {
+		t.Fatalf("expected %v, got %v", leader.revs, learner.revs)
+	}
+
+	// promoted learner's dictionary is discarded before raft replays the
+	// log on it, and kept if raft recovers a snapshot.
+	learner.setPromoted(true)
+	if snapshot, err := leader.Save(); err != nil {
+		t.Fatal(err)
+	} else if err := learner.Recovery(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	learner.discardPromoted()
+	if CAS := learner.GetCAS(); CAS != leader.GetCAS() {
+		t.Fatal("unexpected CAS after recovery", CAS)
+	}
+	learner.setPromoted(true)
+	learner.discardPromoted()
+	if CAS := learner.GetCAS(); CAS != 1 || len(learner.m) != 0 {
+		t.Fatal("unexpected dictionary after discard", CAS, learner.m)
+	}
+}
+
+func TestPromoteBehind(t *testing.T) {
+	var leaderAddr string
+	leader := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set(HttpHdrNameLeaderAddr, leaderAddr)
+			w.Header().Set("ETag", `"5"`)
+		}))
+	defer leader.Close()
+	leaderAddr = leader.URL
+
+	sd, _ := NewSafeDict(nil, true)
+	s := &Server{db: sd, learner: true, leaderInfo: [2]string{"n1", leader.URL}}
+	if err := s.Promote(); err != ErrorLearnerBehind {
+		t.Fatalf("expected %v, got %v", ErrorLearnerBehind, err)
+	}
+	s.learner = false
+	if err := s.Promote(); err != ErrorNotLearner {
+		t.Fatalf("expected %v, got %v", ErrorNotLearner, err)
+	}
+}
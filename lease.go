@@ -0,0 +1,153 @@
+// Leases with time-to-live for fields in SafeDict.
+//
+// Leases are granted and revoked through raft, hence they are part of the
+// replicated state. Fields can be attached to a lease and they are deleted
+// when the lease is revoked. Any other write to a field detaches the field,
+// and its children, from their lease. Expiry of leases is tracked only by
+// the leader, which revokes expired leases through raft. A new leader
+// starts afresh with full time-to-live for every lease.
+
+package failsafe
+
+import (
+	"sort"
+	"time"
+)
+
+// Lease granted on SafeDict.
+type Lease struct {
+	ID    int64           `json:"id"`
+	TTL   time.Duration   `json:"ttl"`
+	Paths map[string]bool `json:"paths"` // attached fields
+}
+
+type leases struct {
+	nextid int64
+	leases map[int64]*Lease
+	paths  map[string]int64 // attached field -> lease id
+}
+
+func newLeases(nextid int64, ls map[int64]*Lease) *leases {
+	if ls == nil {
+		ls = make(map[int64]*Lease)
+	}
+	lss := &leases{nextid: nextid, leases: ls}
+	lss.paths = make(map[string]int64)
+	for id, lease := range ls {
+		for path := range lease.Paths {
+			lss.paths[path] = id
+		}
+	}
+	return lss
+}
+
+// attach field at path to lease.
+func (lss *leases) attach(path string, id int64) {
+	lss.detach(path)
+	lss.leases[id].Paths[path] = true
+	lss.paths[path] = id
+}
+
+// detach field at path and its children from their leases.
+func (lss *leases) detach(path string) {
+	for leased, id := range lss.paths {
+		if isPrefixPath(path, leased) {
+			delete(lss.leases[id].Paths, leased)
+			delete(lss.paths, leased)
+		}
+	}
+}
+
+// GrantLease with time-to-live, return unique id for the lease.
+func (sd *SafeDict) GrantLease(ttl time.Duration) (leaseID int64) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.leases.nextid++
+	leaseID = sd.leases.nextid
+	sd.leases.leases[leaseID] = &Lease{
+		ID: leaseID, TTL: ttl, Paths: make(map[string]bool),
+	}
+	return leaseID
+}
+
+// RevokeLease and delete all fields attached to it. CAS is incremented
+// once if any field is deleted.
+func (sd *SafeDict) RevokeLease(leaseID int64) (nextCAS float64, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	lease, ok := sd.leases.leases[leaseID]
+	if !ok {
+		return nullCAS, ErrorLeaseNotFound
+	}
+	delete(sd.leases.leases, leaseID)
+
+	// delete in sorted order, so that all replicas end up the same.
+	paths := make([]string, 0, len(lease.Paths))
+	for path := range lease.Paths {
+		delete(sd.leases.paths, path)
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var doc interface{} = sd.m
+	changes := make([]Change, 0, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			doc = make(map[string]interface{})
+		} else if newdoc, _, err := patchRemove(doc, path); err == nil {
+			doc = newdoc
+		} else {
+			continue // field is already deleted.
+		}
+		changes = append(changes, Change{Path: path, Op: "delete"})
+	}
+	if len(changes) == 0 {
+		return sd.CAS, nil
+	}
+	oldm, removed := sd.m, make([]string, 0, len(changes))
+	for _, change := range changes {
+		removed = append(removed, change.Path)
+	}
+	sd.m = doc.(map[string]interface{})
+	return sd.commit(oldm, removed, changes), nil
+}
+
+// SetLease is same as Set, additionally attaches the field to lease, which
+// shall delete the field when lease is revoked.
+func (sd *SafeDict) SetLease(path string, value interface{}, CAS float64, leaseID int64) (nextCAS float64, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if _, ok := sd.leases.leases[leaseID]; !ok {
+		return nullCAS, ErrorLeaseNotFound
+	}
+	if nextCAS, err = sd.set(path, value, CAS); err == nil {
+		sd.leases.attach(path, leaseID)
+	}
+	return nextCAS, err
+}
+
+// GetLeaseTTL return time-to-live of lease.
+func (sd *SafeDict) GetLeaseTTL(leaseID int64) (ttl time.Duration, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if lease, ok := sd.leases.leases[leaseID]; ok {
+		return lease.TTL, nil
+	}
+	return 0, ErrorLeaseNotFound
+}
+
+// GetLeases return time-to-live of all granted leases.
+func (sd *SafeDict) GetLeases() map[int64]time.Duration {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	ttls := make(map[int64]time.Duration, len(sd.leases.leases))
+	for id, lease := range sd.leases.leases {
+		ttls[id] = lease.TTL
+	}
+	return ttls
+}
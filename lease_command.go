@@ -0,0 +1,60 @@
+package failsafe
+
+import (
+	"time"
+
+	"github.com/goraft/raft"
+)
+
+// GrantLeaseCommand to grant a lease on SafeDict.
+type GrantLeaseCommand struct {
+	TTL time.Duration `json:"ttl"`
+}
+
+// NewGrantLeaseCommand creates a new instance of GrantLeaseCommand.
+func NewGrantLeaseCommand(ttl time.Duration) *GrantLeaseCommand {
+	return &GrantLeaseCommand{ttl}
+}
+
+// CommandName implements raft.Command interface.
+func (c *GrantLeaseCommand) CommandName() string {
+	return "grantLease"
+}
+
+// Apply implements raft.CommandApply interface.
+func (c *GrantLeaseCommand) Apply(context raft.Context) (interface{}, error) {
+	s := context.Server().Context().(*Server)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
+	return s.db.GrantLease(c.TTL), nil
+}
+
+// RevokeLeaseCommand to revoke a lease and delete fields attached to it.
+type RevokeLeaseCommand struct {
+	Lease int64 `json:"lease"`
+}
+
+// NewRevokeLeaseCommand creates a new instance of RevokeLeaseCommand.
+func NewRevokeLeaseCommand(lease int64) *RevokeLeaseCommand {
+	return &RevokeLeaseCommand{lease}
+}
+
+// CommandName implements raft.Command interface.
+func (c *RevokeLeaseCommand) CommandName() string {
+	return "revokeLease"
+}
+
+// Apply implements raft.CommandApply interface.
+func (c *RevokeLeaseCommand) Apply(context raft.Context) (interface{}, error) {
+	s := context.Server().Context().(*Server)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
+	nextCAS, err := s.db.RevokeLease(c.Lease)
+	return nextCAS, err
+}
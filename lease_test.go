@@ -0,0 +1,115 @@
+package failsafe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLeaseSafeDict(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": 1, "locks": {}}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaseID := sd.GrantLease(time.Second)
+	if ttls := sd.GetLeases(); ttls[leaseID] != time.Second {
+		t.Fatal("failed grant lease", ttls)
+	}
+	if _, err := sd.SetLease("/locks/x", "n1", nullCAS, leaseID+1); err != ErrorLeaseNotFound {
+		t.Fatal("expected lease not found", err)
+	}
+	if _, err := sd.SetLease("/locks/x", "n1", nullCAS, leaseID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sd.SetLease("/locks/y", "n2", nullCAS, leaseID); err != nil {
+		t.Fatal(err)
+	}
+	// plain Set detaches the field from its lease.
+	if _, err := sd.Set("/locks/y", "n3", nullCAS); err != nil {
+		t.Fatal(err)
+	}
+
+	CAS := sd.GetCAS()
+	if nextCAS, err := sd.RevokeLease(leaseID); err != nil {
+		t.Fatal(err)
+	} else if nextCAS != CAS+1 {
+		t.Fatal("expected single increment of CAS", nextCAS)
+	}
+	if _, _, err := sd.Get("/locks/x"); err == nil {
+		t.Fatal("expected field to be deleted on revoke")
+	}
+	if val, _, err := sd.Get("/locks/y"); err != nil || val != "n3" {
+		t.Fatal("failed detached field", val, err)
+	}
+	if _, err := sd.RevokeLease(leaseID); err != ErrorLeaseNotFound {
+		t.Fatal("expected lease not found", err)
+	}
+	if _, err := sd.GetLeaseTTL(leaseID); err != ErrorLeaseNotFound {
+		t.Fatal("expected lease not found", err)
+	}
+}
+
+func TestLeaseDetach(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"locks": {}}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaseID := sd.GrantLease(time.Second)
+	for _, path := range []string{"/locks/a", "/locks/b", "/locks/c", "/locks/d"} {
+		if _, err := sd.SetLease(path, "n1", nullCAS, leaseID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// writes by Patch, Merge and Txn detach fields from their lease.
+	ops := []PatchOp{{Op: "replace", Path: "/locks/a", Value: "n2"}}
+	if _, err := sd.Patch(ops, nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sd.Merge("/locks", map[string]interface{}{"b": "n2"}, nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sd.Txn(&Txn{Then: []TxnOp{OpSet("/locks/c", "n2")}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sd.RevokeLease(leaseID); err != nil {
+		t.Fatal(err)
+	}
+	ref := map[string]interface{}{"a": "n2", "b": "n2", "c": "n2"}
+	if val, _, err := sd.Get("/locks"); err != nil {
+		t.Fatal(err)
+	} else if reflect.DeepEqual(val, ref) == false {
+		t.Fatal("unexpected fields after revoke", val)
+	}
+}
+
+func TestSaveRestoreLeases(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": {"b": 1}}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaseID := sd.GrantLease(2 * time.Second)
+	if _, err := sd.SetLease("/a/b", float64(2), nullCAS, leaseID); err != nil {
+		t.Fatal(err)
+	}
+	data, err := sd.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd1, _ := NewSafeDict(nil, true)
+	if err := sd1.Recovery(data); err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(sd.leases, sd1.leases) == false {
+		t.Fatal("failed save / recovery of leases")
+	}
+	// lease ids are not reused after recovery.
+	if id := sd1.GrantLease(time.Second); id != leaseID+1 {
+		t.Fatal("unexpected lease id", id)
+	}
+	if _, err := sd1.RevokeLease(leaseID); err != nil {
+		t.Fatal(err)
+	} else if _, _, err := sd1.Get("/a/b"); err == nil {
+		t.Fatal("expected field to be deleted on revoke")
+	}
+}
@@ -0,0 +1,85 @@
+// Cluster membership.
+//
+// Members of the cluster are the raft peers along with this server. Members
+// are added and removed through raft, hence these calls shall be made on the
+// leader. Last contact with peers is tracked only by the leader, so are the
+// learners, refer learner.go.
+
+package failsafe
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/goraft/raft"
+)
+
+// ErrorMemberNotFound when removing a server that is not a member.
+var ErrorMemberNotFound = fmt.Errorf("failsafe.errorMemberNotFound")
+
+// Member of the cluster.
+type Member struct {
+	Name             string `json:"name"`
+	ConnectionString string `json:"connectionString"`
+	// Role is the raft state of this server, and one of RoleLeader,
+	// RoleFollower or RoleLearner for others.
+	Role string `json:"role"`
+	// LastContact from the leader, zero for this server and when not
+	// known.
+	LastContact time.Time `json:"lastContact"`
+}
+
+// Members of the cluster, including this server, sorted by name.
+func (s *Server) Members() []Member {
+	leader, role := s.raftServer.Leader(), s.raftServer.State()
+	if s.isLearner() {
+		role = RoleLearner
+	}
+	members := []Member{{
+		Name:             s.raftServer.Name(),
+		ConnectionString: s.connectionString(),
+		Role:             role,
+	}}
+	for name, peer := range s.raftServer.Peers() {
+		role := RoleFollower
+		if name == leader {
+			role = RoleLeader
+		}
+		members = append(members, Member{
+			Name:             name,
+			ConnectionString: peer.ConnectionString,
+			Role:             role,
+			LastContact:      peer.LastActivity(),
+		})
+	}
+	members = append(members, s.getLearners()...)
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	return members
+}
+
+// AddMember to the cluster, server shall be started by joining the leader
+// with the same name and connection string.
+func (s *Server) AddMember(name, connectionString string) error {
+	_, err := s.raftServer.Do(&raft.DefaultJoinCommand{
+		Name:             name,
+		ConnectionString: connectionString,
+	})
+	return err
+}
+
+// RemoveMember from the cluster, like a dead server that is to be replaced.
+// A learner that is removed is listed again when it registers next.
+func (s *Server) RemoveMember(name string) error {
+	if s.raftServer.State() != raft.Leader {
+		return raft.NotLeaderError
+	} else if s.removeLearner(name) {
+		return nil
+	} else if _, ok := s.raftServer.Peers()[name]; !ok && name != s.raftServer.Name() {
+		return ErrorMemberNotFound
+	}
+	_, err := s.raftServer.Do(&raft.DefaultLeaveCommand{Name: name})
+	return err
+}
@@ -0,0 +1,35 @@
+package failsafe
+
+import (
+	"github.com/goraft/raft"
+)
+
+// MergeCommand to apply RFC 7386 JSON Merge Patch on a field in SafeDict.
+type MergeCommand struct {
+	Path  string      `json:"path"`
+	Patch interface{} `json:"patch"`
+	CAS   float64     `json:"CAS"`
+}
+
+// NewMergeCommand creates a new instance of MergeCommand.
+func NewMergeCommand(path string, patch interface{}, cas float64) *MergeCommand {
+	return &MergeCommand{path, patch, cas}
+}
+
+// CommandName implements raft.Command interface.
+func (c *MergeCommand) CommandName() string {
+	return "merge"
+}
+
+// Apply implements raft.CommandApply interface.
+func (c *MergeCommand) Apply(context raft.Context) (interface{}, error) {
+	s := context.Server().Context().(*Server)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
+	nextCAS, err := s.db.Merge(c.Path, c.Patch, c.CAS)
+	s.countConflict(err)
+	return nextCAS, err
+}
@@ -0,0 +1,323 @@
+// Metrics in Prometheus text format.
+//
+// Counters for raft events are picked from Stats, latencies are tracked
+// as histograms with cumulative buckets, all of them are rendered on
+// /metrics in Prometheus text exposition format, version 0.0.4.
+
+package failsafe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goraft/raft"
+)
+
+// latencyBuckets are upper bounds, in seconds, for latency histograms.
+var latencyBuckets = []float64{
+	.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// requestMethods are labelled by name, other methods are labelled as
+// "other", so that clients cannot create unbounded series.
+var requestMethods = []string{"GET", "HEAD", "PUT", "POST", "PATCH", "DELETE"}
+
+// counters picked from Stats, as metric name, help and key in Stats.
+var statsCounters = [][3]string{
+	{"failsafe_raft_commits_total", "Raft entries committed.", "raftCommit"},
+	{"failsafe_raft_elections_total", "Elections started by this server.", "raftElection"},
+	{"failsafe_raft_state_changes_total", "Raft state changes.", "raftStateChange"},
+	{"failsafe_raft_leader_changes_total", "Leader changes.", "raftLeaderChange"},
+	{"failsafe_raft_term_changes_total", "Raft term changes.", "raftTermChange"},
+	{"failsafe_raft_heartbeats_total", "Raft heartbeats.", "raftHeartbeat"},
+	{"failsafe_raft_peers_added_total", "Raft peers added.", "raftAddPeer"},
+	{"failsafe_raft_peers_removed_total", "Raft peers removed.", "raftRemovePeer"},
+	{"failsafe_snapshots_total", "Snapshots taken.", "snapshotCount"},
+	{"failsafe_snapshot_errors_total", "Snapshots failed.", "snapshotErrors"},
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative.
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+// since observes the time elapsed since start.
+func (h *histogram) since(start time.Time) {
+	h.observe(time.Since(start).Seconds())
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.SearchFloat64s(latencyBuckets, value)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+}
+
+// write histogram as metric name with labels, labels shall be formatted
+// as `key="value",`.
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%v\"} %v\n", name, labels, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %v\n", name, labels, h.count)
+	if labels = strings.TrimSuffix(labels, ","); labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %v\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %v\n", name, labels, h.count)
+}
+
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[[2]string]*histogram // request latency by handler, method.
+	errors    map[string]uint64        // request errors by code.
+	conflicts uint64                   // writes failed with CAS mismatch.
+	apply     *histogram               // latency of applying commands.
+	snapshot  *histogram               // latency of taking snapshots.
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[[2]string]*histogram),
+		errors:   make(map[string]uint64),
+		apply:    newHistogram(),
+		snapshot: newHistogram(),
+	}
+}
+
+func (m *metrics) request(handler, method string) *histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{handler, method}
+	h, ok := m.requests[key]
+	if !ok {
+		h = newHistogram()
+		m.requests[key] = h
+	}
+	return h
+}
+
+func (m *metrics) requestError(code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[code]++
+}
+
+// countConflict if err is a CAS mismatch, called where writes are applied
+// so that conflicts are counted for requests and for in-process calls.
+func (s *Server) countConflict(err error) {
+	if err == ErrorInvalidCAS {
+		s.metrics.mu.Lock()
+		s.metrics.conflicts++
+		s.metrics.mu.Unlock()
+	}
+}
+
+// metricsWriter remembers the HTTP status of the response, and the error
+// code set by writeJSON.
+type metricsWriter struct {
+	http.ResponseWriter
+	status int
+	code   string
+}
+
+func (mw *metricsWriter) WriteHeader(status int) {
+	if mw.status == 0 {
+		mw.status = status
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metricsWriter) Write(data []byte) (int, error) {
+	if mw.status == 0 {
+		mw.status = http.StatusOK
+	}
+	return mw.ResponseWriter.Write(data)
+}
+
+// instrument handler to measure request latency and count errors. Failed
+// requests are counted by their error code, and by HTTP status when the
+// response carries no error code, like http.Error responses.
+func (s *Server) instrument(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		method := req.Method
+		if !hasString(requestMethods, method) {
+			method = "other"
+		}
+		defer s.metrics.request(pattern, method).since(time.Now())
+		mw := &metricsWriter{ResponseWriter: w}
+		handler(mw, req)
+		if mw.status < http.StatusBadRequest {
+			return
+		} else if mw.code != "" {
+			s.metrics.requestError(mw.code)
+		} else {
+			s.metrics.requestError(strconv.Itoa(mw.status))
+		}
+	}
+}
+
+// WriteMetrics of this server in Prometheus text format.
+func (s *Server) WriteMetrics(w io.Writer) {
+	stats := s.GetStats()
+	for _, counter := range statsCounters {
+		writeMetric(w, counter[0], "counter", counter[1], toFloat(stats[counter[2]]))
+	}
+
+	s.metrics.mu.Lock()
+	codes := make([]string, 0, len(s.metrics.errors))
+	for code := range s.metrics.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	fmt.Fprintf(w, "# HELP failsafe_request_errors_total Requests failed, by error code or HTTP status.\n")
+	fmt.Fprintf(w, "# TYPE failsafe_request_errors_total counter\n")
+	for _, code := range codes {
+		fmt.Fprintf(w, "failsafe_request_errors_total{code=%q} %v\n", code, s.metrics.errors[code])
+	}
+	conflicts := s.metrics.conflicts
+	keys := make([][2]string, 0, len(s.metrics.requests))
+	for key := range s.metrics.requests {
+		keys = append(keys, key)
+	}
+	s.metrics.mu.Unlock()
+
+	writeMetric(w, "failsafe_cas_conflicts_total", "counter",
+		"Writes failed with CAS mismatch.", float64(conflicts))
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	name := "failsafe_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of requests, by handler and method.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, key := range keys {
+		labels := fmt.Sprintf("handler=%q,method=%q,", key[0], key[1])
+		s.metrics.request(key[0], key[1]).write(w, name, labels)
+	}
+
+	name = "failsafe_apply_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of applying commands to the dictionary.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	s.metrics.apply.write(w, name, "")
+	name = "failsafe_snapshot_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of taking snapshots.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	s.metrics.snapshot.write(w, name, "")
+
+	writeMetric(w, "failsafe_snapshot_size_bytes", "gauge",
+		"Size of the latest snapshot.", toFloat(stats["snapshotSize"]))
+	writeMetric(w, "failsafe_raft_log_size_bytes", "gauge",
+		"Size of the raft log.", toFloat(stats["raftLogSize"]))
+	writeMetric(w, "failsafe_dict_fields", "gauge",
+		"Number of fields in the dictionary.", float64(s.db.countFields()))
+	writeMetric(w, "failsafe_dict_cas", "gauge",
+		"CAS of the dictionary.", s.db.GetCAS())
+	if s.raftServer != nil {
+		writeMetric(w, "failsafe_raft_term", "gauge",
+			"Current raft term.", float64(s.raftServer.Term()))
+		writeMetric(w, "failsafe_raft_commit_index", "gauge",
+			"Raft commit index.", float64(s.raftServer.CommitIndex()))
+		var leader float64
+		if s.raftServer.State() == raft.Leader {
+			leader = 1
+		}
+		writeMetric(w, "failsafe_raft_leader", "gauge",
+			"Whether this server is the leader.", leader)
+	}
+	writeMetric(w, "failsafe_raft_applied_index", "gauge",
+		"Index of the last entry applied to the dictionary.",
+		float64(s.getAppliedIndex()))
+}
+
+// metricsHandler renders metrics in Prometheus text format.
+func (s *Server) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	if req.Method != "GET" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	var b bytes.Buffer
+	s.WriteMetrics(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+func writeMetric(w io.Writer, name, typ, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	case time.Duration:
+		return v.Seconds()
+	}
+	return 0
+}
+
+// countFields in the dictionary, including the root.
+func (sd *SafeDict) countFields() int {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	if sd.m == nil {
+		return 0
+	}
+	return countJSON(sd.m)
+}
+
+func countJSON(value interface{}) int {
+	n := 1
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, x := range v {
+			n += countJSON(x)
+		}
+	case []interface{}:
+		for _, x := range v {
+			n += countJSON(x)
+		}
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+package failsafe
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHistogram(t *testing.T) {
+	h := newHistogram()
+	h.observe(.0001)
+	h.observe(.003)
+	h.observe(100)
+
+	var b bytes.Buffer
+	h.write(&b, "x", "")
+	out := b.String()
+	for _, line := range []string{
+		`x_bucket{le="0.0005"} 1`,
+		`x_bucket{le="0.0025"} 1`,
+		`x_bucket{le="0.005"} 2`,
+		`x_bucket{le="10"} 2`,
+		`x_bucket{le="+Inf"} 3`,
+		`x_count 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expected %q in %s", line, out)
+		}
+	}
+
+	b.Reset()
+	h.write(&b, "x", `handler="/dict",`)
+	if out := b.String(); !strings.Contains(out, `x_count{handler="/dict"} 3`) {
+		t.Fatal("unexpected output", out)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	sd, err := NewSafeDict(smallJSON, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: sd, metrics: newMetrics(), stats: NewStats()}
+	s.incrStat("raftCommit")
+
+	// conflicts are counted where writes are applied.
+	rs := &testRaftServer{context: s}
+	context := &testContext{server: rs, index: 1}
+	if _, err := NewSetCommand("/eyeColor", "red", 100).Apply(context); err != ErrorInvalidCAS {
+		t.Fatalf("expected %v, got %v", ErrorInvalidCAS, err)
+	}
+
+	handler := s.instrument("/dict", func(w http.ResponseWriter, req *http.Request) {
+		err := ErrorInvalidCAS
+		m := map[string]interface{}{"err": errorString(err), "code": errorCode(err)}
+		writeJSON(w, req, m)
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/dict/a", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatal("unexpected status", w.Code)
+	}
+
+	// errors without error code are counted by HTTP status.
+	handler = s.instrument("/dict", func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	})
+	for _, method := range []string{"TRACE", "FROB"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(method, "/dict/a", nil))
+	}
+	handler = s.instrument("/dict", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, req, map[string]interface{}{"err": "", "code": ""})
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/dict/a", nil))
+
+	w = httptest.NewRecorder()
+	s.metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status", w.Code)
+	}
+	out := w.Body.String()
+	for _, line := range []string{
+		`failsafe_raft_commits_total 1`,
+		`failsafe_request_errors_total{code="cas-mismatch"} 1`,
+		`failsafe_request_errors_total{code="405"} 2`,
+		`failsafe_request_duration_seconds_count{handler="/dict",method="other"} 2`,
+		`failsafe_cas_conflicts_total 1`,
+		`failsafe_request_duration_seconds_count{handler="/dict",method="PUT"} 1`,
+		`failsafe_apply_duration_seconds_count 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expected %q in %s", line, out)
+		}
+	}
+	if strings.Contains(out, `method="FROB"`) {
+		t.Fatal("unexpected method label", out)
+	} else if strings.Contains(out, `failsafe_request_errors_total{code=""}`) {
+		t.Fatal("unexpected error for successful request", out)
+	}
+
+	w = httptest.NewRecorder()
+	s.metricsHandler(w, httptest.NewRequest("POST", "/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatal("unexpected status", w.Code)
+	}
+}
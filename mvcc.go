@@ -0,0 +1,149 @@
+// Multi-version reads on SafeDict.
+//
+// Every write to the dictionary remembers the previous value of the field it
+// modified, as an undo entry. Value of a field at an older CAS is computed
+// by undoing writes that happened after that CAS. Undo entries are retained
+// for a window of recent CAS and older entries are compacted automatically,
+// they can also be compacted explicitly. Versions are disabled for a new
+// SafeDict, Server retains versions for defaultVersionWindow.
+//
+// Older versions are not part of snapshots, hence they are dropped when
+// the dictionary is recovered or restored from a snapshot.
+
+package failsafe
+
+import (
+	"sort"
+)
+
+type undo struct {
+	CAS   float64
+	path  string
+	value interface{} // previous value, detached from the dictionary.
+	ok    bool        // whether field was present before the write.
+}
+
+type mvcc struct {
+	entries   []undo  // ordered by CAS
+	compacted float64 // versions older than compacted CAS are not available
+	window    float64 // versions retained, in terms of CAS, zero disables
+}
+
+func newMVCC(CAS, window float64) *mvcc {
+	return &mvcc{entries: make([]undo, 0), compacted: CAS, window: window}
+}
+
+// add undo entry for a write at CAS, entries falling out of the window are
+// compacted.
+func (mv *mvcc) add(CAS float64, path string, value interface{}, ok bool) {
+	if mv.window == 0 {
+		return
+	}
+	mv.entries = append(mv.entries, undo{CAS, path, value, ok})
+	mv.compact(CAS - mv.window)
+}
+
+// compact undo entries upto CAS.
+func (mv *mvcc) compact(CAS float64) {
+	if CAS <= mv.compacted {
+		return
+	}
+	n := sort.Search(len(mv.entries), func(i int) bool {
+		return mv.entries[i].CAS > CAS
+	})
+	for i := 0; i < n; i++ {
+		mv.entries[i] = undo{} // release old values.
+	}
+	mv.entries, mv.compacted = mv.entries[n:], CAS
+}
+
+// available return true if version at CAS is not compacted, current is the
+// dictionary's CAS.
+func (mv *mvcc) available(CAS, current float64) bool {
+	if mv.window == 0 {
+		return CAS >= current
+	}
+	return CAS >= mv.compacted
+}
+
+// rewind value of field at path, to its version at CAS, by undoing writes
+// that happened after CAS. ok tells whether the field is present in its
+// current version.
+func (mv *mvcc) rewind(
+	path string, value interface{}, ok bool,
+	CAS float64) (interface{}, bool) {
+
+	value = copyJSON(value)
+	for i := len(mv.entries) - 1; i >= 0 && mv.entries[i].CAS > CAS; i-- {
+		entry := mv.entries[i]
+		switch {
+		case isPrefixPath(entry.path, path): // write to a parent field.
+			value, ok = nil, false
+			if entry.ok {
+				relpath := path[len(entry.path):]
+				v, found := patchGet(entry.value, relpath)
+				value, ok = copyJSON(v), found
+			}
+
+		case ok && isPrefixPath(path, entry.path): // write to a child field.
+			relpath := entry.path[len(path):]
+			if entry.ok {
+				value, _ = patchReplace(value, relpath, copyJSON(entry.value))
+			} else {
+				value, _, _ = patchRemove(value, relpath)
+			}
+		}
+	}
+	return value, ok
+}
+
+// isPrefixPath return true if jsonpointer prefix is same as path or is one
+// of its parents.
+func isPrefixPath(prefix, path string) bool {
+	if len(path) < len(prefix) || path[:len(prefix)] != prefix {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+// GetAt return value of the field located by `path` jsonpointer, as it was
+// at CAS. If versions older than CAS are compacted, or dropped by recovering
+// from a snapshot, ErrorCompacted is returned.
+func (sd *SafeDict) GetAt(path string, CAS float64) (rv interface{}, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if CAS > sd.CAS {
+		return nil, ErrorInvalidCAS
+	} else if !sd.mvcc.available(CAS, sd.CAS) {
+		return nil, ErrorCompacted
+	}
+	value, ok := sd.lookup(path)
+	if rv, ok = sd.mvcc.rewind(path, value, ok, CAS); !ok || rv == nil {
+		return nil, ErrorInvalidPath
+	}
+	return rv, nil
+}
+
+// Compact versions older than CAS, GetAt() for CAS older than compacted CAS
+// shall fail.
+func (sd *SafeDict) Compact(CAS float64) (nextCAS float64, err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if CAS > sd.CAS {
+		return nullCAS, ErrorInvalidCAS
+	}
+	sd.mvcc.compact(CAS)
+	return sd.CAS, nil
+}
+
+// SetVersionWindow to retain older versions for `window` recent CAS, older
+// versions are compacted automatically. Window of zero disables versions,
+// existing versions are compacted.
+func (sd *SafeDict) SetVersionWindow(window float64) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.mvcc = newMVCC(sd.CAS, window)
+}
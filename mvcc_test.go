@@ -0,0 +1,130 @@
+package failsafe
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGetAt(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": {"b": 1, "c": [1, 2]}}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.SetVersionWindow(100)
+	sd.Set("/a/b", float64(2), nullCAS)                          // CAS 2
+	sd.Set("/a/d", "x", nullCAS)                                 // CAS 3
+	sd.Delete("/a/b", nullCAS)                                   // CAS 4
+	sd.Set("/a/c/1", float64(20), nullCAS)                       // CAS 5
+	sd.Patch([]PatchOp{{Op: "remove", Path: "/a/c/0"}}, nullCAS) // CAS 6
+	sd.Set("", map[string]interface{}{"z": true}, nullCAS)       // CAS 7
+
+	testcases := []struct {
+		path  string
+		CAS   float64
+		value string
+	}{
+		{"/a/b", 1, `1`}, {"/a/b", 2, `2`}, {"/a/b", 3, `2`}, {"/a/b", 4, ``},
+		{"/a", 1, `{"b":1,"c":[1,2]}`},
+		{"/a", 3, `{"b":2,"c":[1,2],"d":"x"}`},
+		{"/a", 5, `{"c":[1,20],"d":"x"}`},
+		{"/a/c", 6, `[20]`},
+		{"/a", 7, ``},
+		{"", 7, `{"z":true}`},
+		{"/a/d", 2, ``},
+		{"/a/c/1", 4, `2`},
+	}
+	for _, tcase := range testcases {
+		value, err := sd.GetAt(tcase.path, tcase.CAS)
+		if tcase.value == "" {
+			if err != ErrorInvalidPath {
+				t.Fatalf("%q at %v expected ErrorInvalidPath, %v", tcase.path, tcase.CAS, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		} else if !jsonEqual(value, jsonDecode(tcase.value)) {
+			t.Fatalf("%q at %v expected %v, got %v", tcase.path, tcase.CAS, tcase.value, value)
+		}
+	}
+
+	// versions shall not share values with the dictionary.
+	value, _ := sd.GetAt("", 7)
+	value.(map[string]interface{})["z"] = false
+	if val, _, _ := sd.Get("/z"); val != true {
+		t.Fatal("version shares value with dictionary")
+	}
+
+	if _, err := sd.GetAt("/z", 8); err != ErrorInvalidCAS {
+		t.Fatal("expected ErrorInvalidCAS", err)
+	}
+	if _, err := sd.Compact(4); err != nil {
+		t.Fatal(err)
+	} else if _, err := sd.GetAt("/a/b", 3); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted", err)
+	} else if value, err := sd.GetAt("/a/c", 4); err != nil {
+		t.Fatal(err)
+	} else if reflect.DeepEqual(value, jsonDecode(`[1,2]`)) == false {
+		t.Fatal("unexpected value after compaction", value)
+	} else if len(sd.mvcc.entries) != 3 {
+		t.Fatal("expected 3 undo entries", len(sd.mvcc.entries))
+	}
+}
+
+func TestVersionWindow(t *testing.T) {
+	sd, _ := NewSafeDict([]byte(`{"a": 1}`), true)
+	sd.Set("/a", float64(2), nullCAS) // CAS 2
+	if _, err := sd.GetAt("/a", 1); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted without versions", err)
+	} else if value, err := sd.GetAt("/a", 2); err != nil {
+		t.Fatal(err)
+	} else if value != float64(2) {
+		t.Fatal("unexpected value", value)
+	}
+
+	// versions falling out of window are compacted.
+	sd.SetVersionWindow(2)
+	for i := 3; i <= 6; i++ {
+		sd.Set("/a", float64(i), nullCAS)
+	}
+	if _, err := sd.GetAt("/a", 3); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted", err)
+	} else if value, err := sd.GetAt("/a", 4); err != nil {
+		t.Fatal(err)
+	} else if value != float64(4) {
+		t.Fatal("unexpected value", value)
+	}
+
+	// versions are dropped on recovery.
+	data, _ := sd.Save()
+	if err := sd.Recovery(data); err != nil {
+		t.Fatal(err)
+	} else if _, err := sd.GetAt("/a", 5); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted after recovery", err)
+	}
+	sd.Set("/a", float64(7), nullCAS)
+	if value, err := sd.GetAt("/a", 6); err != nil {
+		t.Fatal(err)
+	} else if value != float64(6) {
+		t.Fatal("unexpected value", value)
+	}
+}
+
+func TestIsPrefixPath(t *testing.T) {
+	testcases := []struct {
+		prefix, path string
+		ok           bool
+	}{
+		{"", "", true}, {"", "/a", true}, {"/a", "/a", true}, {"/a", "/a/b", true},
+		{"/a", "/ab", false}, {"/a/b", "/a", false}, {"/a", "", false},
+	}
+	for _, tcase := range testcases {
+		if isPrefixPath(tcase.prefix, tcase.path) != tcase.ok {
+			t.Fatalf("isPrefixPath(%q, %q) expected %v", tcase.prefix, tcase.path, tcase.ok)
+		}
+	}
+}
+
+func jsonDecode(data string) (value interface{}) {
+	json.Unmarshal([]byte(data), &value)
+	return value
+}
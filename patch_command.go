@@ -0,0 +1,34 @@
+package failsafe
+
+import (
+	"github.com/goraft/raft"
+)
+
+// PatchCommand to apply RFC 6902 JSON Patch document on SafeDict.
+type PatchCommand struct {
+	Ops []PatchOp `json:"ops"`
+	CAS float64   `json:"CAS"`
+}
+
+// NewPatchCommand creates a new instance of PatchCommand.
+func NewPatchCommand(ops []PatchOp, cas float64) *PatchCommand {
+	return &PatchCommand{ops, cas}
+}
+
+// CommandName implements raft.Command interface.
+func (c *PatchCommand) CommandName() string {
+	return "patch"
+}
+
+// Apply implements raft.CommandApply interface.
+func (c *PatchCommand) Apply(context raft.Context) (interface{}, error) {
+	s := context.Server().Context().(*Server)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
+	nextCAS, err := s.db.Patch(c.Ops, c.CAS)
+	s.countConflict(err)
+	return nextCAS, err
+}
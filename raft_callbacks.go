@@ -2,6 +2,7 @@ package failsafe
 
 import (
 	"github.com/goraft/raft"
+	"strings"
 	"time"
 )
 
@@ -24,49 +25,59 @@ func (s *Server) AddEventListeners() {
 func (s *Server) raftStateChange(e raft.Event) {
 	state, oldState := e.Value().(string), e.PrevValue().(string)
 	tracef("%v, changes state from %q to %q\n", s.logPrefix, oldState, state)
-	s.stats["raftStateChange"] = s.stats["raftStateChange"].(int) + 1
+	s.incrStat("raftStateChange")
+	if state == raft.Candidate {
+		s.incrStat("raftElection")
+	}
 }
 
 func (s *Server) raftLeaderChange(e raft.Event) {
 	leader, oldLeader := e.Value().(string), e.PrevValue().(string)
 	tracef("%v, leader changed from %q to %q\n", s.logPrefix, oldLeader, leader)
-	s.stats["raftLeaderChange"] = s.stats["raftLeaderChange"].(int) + 1
+	s.incrStat("raftLeaderChange")
 }
 
 func (s *Server) raftTermChange(e raft.Event) {
 	term, oldTerm := e.Value().(string), e.PrevValue().(string)
 	tracef("%v, term changed from %q to %q\n", s.logPrefix, oldTerm, term)
-	s.stats["raftTermChange"] = s.stats["raftTermChange"].(int) + 1
+	s.incrStat("raftTermChange")
 }
 
+// raftCommit is notified before the entry is applied, applied index for
+// dictionary commands is recorded by Server.apply once they are applied.
+// Raft's own entries, nop and membership changes, do not touch the
+// dictionary and are recorded here.
 func (s *Server) raftCommit(e raft.Event) {
-	s.stats["raftCommit"] = s.stats["raftCommit"].(int) + 1
+	entry, ok := e.Value().(*raft.LogEntry)
+	if ok && strings.HasPrefix(entry.CommandName(), "raft:") {
+		s.setAppliedIndex(entry.Index())
+		s.setRecovered(false)
+	}
+	s.incrStat("raftCommit")
 }
 
 func (s *Server) raftAddPeer(e raft.Event) {
 	peer := e.Value().(string)
 	tracef("%v, add peer %q\n", s.logPrefix, peer)
-	s.stats["raftAddPeer"] = s.stats["raftAddPeer"].(int) + 1
+	s.incrStat("raftAddPeer")
 }
 
 func (s *Server) raftRemovePeer(e raft.Event) {
 	peer := e.Value().(string)
 	tracef("%v, add peer %q\n", s.logPrefix, peer)
-	s.stats["raftRemovePeer"] = s.stats["raftRemovePeer"].(int) + 1
+	s.incrStat("raftRemovePeer")
 }
 
 func (s *Server) raftHeartbeat(e raft.Event) {
-	s.stats["raftHeartbeat"] = s.stats["raftHeartbeat"].(int) + 1
+	s.incrStat("raftHeartbeat")
 }
 
 func (s *Server) raftHeartbeatInterval(e raft.Event) {
-	v := s.stats["raftHeartbeatInterval"].(int) + 1
-	s.stats["raftHeartbeatInterval"] = v
+	s.incrStat("raftHeartbeatInterval")
 }
 
 func (s *Server) raftElectionTimeoutThreshold(e raft.Event) {
 	elapsedTime := e.Value().(time.Duration)
 	tracef("%v, elapsed time %v\n", s.logPrefix, elapsedTime)
-	v := s.stats["raftElectionTimeoutThreshold"].(int) + 1
-	s.stats["raftElectionTimeoutThreshold"] = v
+	s.incrStat("raftElectionTimeoutThreshold")
 }
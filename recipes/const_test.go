@@ -0,0 +1,52 @@
+// contains common test fixtures.
+
+package recipes
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prataprc/go-failsafe"
+)
+
+var testRaftdir = filepath.Join("..", "testdata", "server", "recipes")
+var listAddr = "localhost:4010"
+var servAddr = "http://" + listAddr
+
+var testServer *failsafe.Server
+
+func init() {
+	failsafe.RegisterCommands()
+}
+
+// startTestServer once for all recipe tests.
+func startTestServer() {
+	if testServer != nil {
+		return
+	}
+	log.SetOutput(ioutil.Discard)
+	if err := os.RemoveAll(testRaftdir); err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	srv, err := failsafe.NewServer("recipes", testRaftdir, listAddr, mux)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Install(""); err != nil {
+		log.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", listAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go (&http.Server{Addr: listAddr, Handler: mux}).Serve(lis)
+	time.Sleep(10 * time.Millisecond)
+	testServer = srv
+}
@@ -0,0 +1,191 @@
+// Leader election among clients.
+//
+// Candidates campaign by creating a field under the election prefix, keyed
+// by their lease id and attached to their lease. Candidate whose field was
+// created at the lowest CAS is the leader, others wait for the candidates
+// created before them to go away. Resigning, or failing to keep the lease
+// alive, deletes the candidate's field and the next candidate takes over.
+
+package recipes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prataprc/go-failsafe"
+)
+
+// ErrorNoLeader is returned when there are no candidates in an election.
+var ErrorNoLeader = fmt.Errorf("recipes.errorNoLeader")
+
+// Election identified by a jsonpointer prefix.
+type Election struct {
+	client *Client
+	prefix string
+	mu     sync.Mutex
+	sess   *session // active campaign, if any.
+}
+
+// Election return a new election on prefix, candidates are kept as
+// properties under prefix.
+func (c *Client) Election(prefix string) *Election {
+	return &Election{client: c, prefix: prefix}
+}
+
+// Campaign for leadership with value, blocks until elected, ctx is done or
+// the campaign's lease is lost. Value is observed by others as leader's
+// value.
+func (e *Election) Campaign(ctx context.Context, value interface{}) error {
+	sess, err := e.client.newSession()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	if e.sess != nil {
+		e.sess.close()
+	}
+	e.sess = sess
+	e.mu.Unlock()
+
+	if err := e.campaign(ctx, sess, value); err != nil {
+		e.mu.Lock()
+		if e.sess == sess {
+			e.sess = nil
+		}
+		e.mu.Unlock()
+		sess.close()
+		return err
+	}
+	return nil
+}
+
+func (e *Election) campaign(ctx context.Context, sess *session, value interface{}) error {
+	client := sess.client
+	key := e.prefix + "/" + strconv.FormatInt(sess.leaseID, 10)
+	if err := makeParents(client, key); err != nil {
+		return err
+	}
+	if _, err := client.SetLease(key, value, sess.leaseID); err != nil {
+		return err
+	}
+	for {
+		CAS, err := client.GetCAS()
+		if err != nil {
+			return err
+		}
+		leader, _, err := e.leader(client)
+		if err == ErrorNoLeader {
+			return ErrorLeaseLost // our own candidature is gone.
+		} else if err != nil {
+			return err
+		} else if leader == key {
+			return nil
+		}
+
+		// wait for candidates ahead of us to go away.
+		changes, cancel := client.Watch(e.prefix, CAS)
+		select {
+		case <-changes:
+		case <-sess.done:
+			cancel()
+			return ErrorLeaseLost
+		case <-ctx.Done():
+			cancel()
+			return ctx.Err()
+		}
+		cancel()
+	}
+}
+
+// Resign from leadership, or withdraw from an ongoing campaign.
+func (e *Election) Resign() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.sess == nil {
+		return nil
+	}
+	err := e.sess.close()
+	e.sess = nil
+	return err
+}
+
+// Leader return current leader's value, ErrorNoLeader if there are no
+// candidates.
+func (e *Election) Leader() (value interface{}, err error) {
+	client := failsafe.NewSafeDictClient(e.client.serverAddr)
+	_, value, err = e.leader(client)
+	return value, err
+}
+
+// Observe leadership, every new leader's value is sent on the returned
+// channel until ctx is done.
+func (e *Election) Observe(ctx context.Context) <-chan interface{} {
+	ch := make(chan interface{})
+	go e.observe(ctx, ch)
+	return ch
+}
+
+func (e *Election) observe(ctx context.Context, ch chan<- interface{}) {
+	defer close(ch)
+
+	client := failsafe.NewSafeDictClient(e.client.serverAddr)
+	var current string
+	for {
+		CAS, err := client.GetCAS()
+		if err != nil {
+			return
+		}
+		leader, value, err := e.leader(client)
+		if err != nil && err != ErrorNoLeader {
+			return
+		} else if err == nil && leader != current {
+			select {
+			case ch <- value:
+			case <-ctx.Done():
+				return
+			}
+		}
+		current = leader
+
+		changes, cancel := client.Watch(e.prefix, CAS)
+		select {
+		case <-changes:
+		case <-ctx.Done():
+			cancel()
+			return
+		}
+		cancel()
+	}
+}
+
+// leader return the candidate created at the lowest CAS.
+func (e *Election) leader(client *failsafe.SafeDictClient) (key string, value interface{}, err error) {
+	v, _, err := client.Get(e.prefix)
+	if err == failsafe.ErrorInvalidPath {
+		return "", nil, ErrorNoLeader
+	} else if err != nil {
+		return "", nil, err
+	}
+	candidates, _ := v.(map[string]interface{})
+
+	var minrev float64
+	for name, val := range candidates {
+		path := e.prefix + "/" + name
+		_, _, rev, err := client.GetRev(path)
+		if err == failsafe.ErrorInvalidPath {
+			continue // candidate went away.
+		} else if err != nil {
+			return "", nil, err
+		}
+		if key == "" || rev.Create < minrev {
+			key, value, minrev = path, val, rev.Create
+		}
+	}
+	if key == "" {
+		return "", nil, ErrorNoLeader
+	}
+	return key, value, nil
+}
@@ -0,0 +1,65 @@
+package recipes
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestElection(t *testing.T) {
+	startTestServer()
+
+	client, err := NewClient(servAddr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e1, e2 := client.Election("/election/master"), client.Election("/election/master")
+	if _, err := e1.Leader(); err != ErrorNoLeader {
+		t.Fatal("expected no leader", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	observech := e1.Observe(ctx)
+
+	if err := e1.Campaign(context.Background(), "node1"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := e2.Leader(); err != nil {
+		t.Fatal(err)
+	} else if value != "node1" {
+		t.Fatal("unexpected leader", value)
+	}
+
+	electedch := make(chan error)
+	go func() { electedch <- e2.Campaign(context.Background(), "node2") }()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-electedch:
+		t.Fatal("expected node2 to wait for node1 to resign")
+	default:
+	}
+	if err := e1.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-electedch:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected node2 to be elected")
+	}
+
+	for _, ref := range []string{"node1", "node2"} {
+		select {
+		case value := <-observech:
+			if value != ref {
+				t.Fatal("unexpected observed leader", value)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected to observe", ref)
+		}
+	}
+	e2.Resign()
+}
@@ -0,0 +1,97 @@
+// Distributed lock on a jsonpointer path.
+//
+// Lock is held by the client that managed to create the field at path,
+// value of the field is the lease id of the holder and the field is
+// attached to that lease. Other clients watch the path and retry when the
+// field changes. CAS at which the field was created is handed out as
+// fencing token, it increases monotonically across lock holders.
+
+package recipes
+
+import (
+	"context"
+
+	"github.com/prataprc/go-failsafe"
+)
+
+// Lock held on a jsonpointer path.
+type Lock struct {
+	path  string
+	token uint64
+	sess  *session
+}
+
+// Lock path, blocks until the lock is acquired or ctx is done.
+func (c *Client) Lock(ctx context.Context, path string) (*Lock, error) {
+	sess, err := c.newSession()
+	if err != nil {
+		return nil, err
+	}
+	lock := &Lock{path: path, sess: sess}
+	if err := lock.acquire(ctx); err != nil {
+		sess.close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (l *Lock) acquire(ctx context.Context) error {
+	client := l.sess.client
+	if err := makeParents(client, l.path); err != nil {
+		return err
+	}
+	for {
+		CAS, err := client.GetCAS()
+		if err != nil {
+			return err
+		}
+		value, _, rev, err := client.GetRev(l.path)
+		if err == failsafe.ErrorInvalidPath {
+			l.token, err = client.SetLeaseCAS(l.path, l.sess.leaseID, CAS, l.sess.leaseID)
+			if err == nil {
+				return nil
+			} else if err != failsafe.ErrorInvalidCAS {
+				return err
+			}
+			continue // lost the race, try again.
+
+		} else if err != nil {
+			return err
+
+		} else if owner, ok := value.(float64); ok && int64(owner) == l.sess.leaseID {
+			l.token = uint64(rev.Modify)
+			return nil
+		}
+
+		// wait for the holder to release the lock.
+		changes, cancel := client.Watch(l.path, CAS)
+		select {
+		case <-changes:
+		case <-l.sess.done:
+			cancel()
+			return ErrorLeaseLost
+		case <-ctx.Done():
+			cancel()
+			return ctx.Err()
+		}
+		cancel()
+	}
+}
+
+// Token return the fencing token for this lock, writes to the protected
+// resource shall be rejected if they carry a token older than the latest
+// one seen.
+func (l *Lock) Token() uint64 {
+	return l.token
+}
+
+// Done returns a channel that is closed when the lock is lost, because
+// its lease could not be kept alive, or when the lock is released.
+func (l *Lock) Done() <-chan struct{} {
+	return l.sess.done
+}
+
+// Unlock path, deleting the field held for the lock.
+func (l *Lock) Unlock() error {
+	return l.sess.close()
+}
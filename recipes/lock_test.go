@@ -0,0 +1,57 @@
+package recipes
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	startTestServer()
+
+	client, err := NewClient(servAddr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock1, err := client.Lock(context.Background(), "/locks/rebalance")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// second lock shall wait for the first to be released.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Lock(ctx, "/locks/rebalance"); err != context.DeadlineExceeded {
+		t.Fatal("expected lock to time out", err)
+	}
+
+	lockch := make(chan *Lock)
+	go func() {
+		lock2, err := client.Lock(context.Background(), "/locks/rebalance")
+		if err != nil {
+			t.Error(err)
+		}
+		lockch <- lock2
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := lock1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lock1.Done():
+	default:
+		t.Fatal("expected Done() to be closed on Unlock()")
+	}
+
+	select {
+	case lock2 := <-lockch:
+		if lock2 == nil {
+			t.Fatal("failed second lock")
+		} else if lock2.Token() <= lock1.Token() {
+			t.Fatal("expected fencing token to increase", lock2.Token())
+		}
+		lock2.Unlock()
+	case <-time.After(time.Second):
+		t.Fatal("expected second lock to be acquired")
+	}
+}
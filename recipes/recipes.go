@@ -0,0 +1,149 @@
+// Package recipes implement coordination primitives, like distributed
+// locks, on top of fail-safe dictionary.
+//
+// Recipes talk to the dictionary using failsafe.SafeDictClient. Ownership
+// is established by CAS guarded writes and fields are attached to a lease,
+// that is kept alive in background for as long as the ownership is held.
+// If the client dies its lease expires and the fields are deleted, letting
+// other clients take over.
+//
+// Example lock {
+//      client, err := recipes.NewClient(servAddr, 10*time.Second)
+//      if err != nil {
+//          return err
+//      }
+//      lock, err := client.Lock(ctx, "/locks/rebalance")
+//      if err != nil {
+//          return err
+//      }
+//      defer lock.Unlock()
+//      // lock.Token() shall be passed along with every write to the
+//      // protected resource, as fencing token.
+// }
+
+package recipes
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prataprc/go-failsafe"
+)
+
+// ErrorLeaseLost is returned when the lease backing a recipe could not be
+// kept alive.
+var ErrorLeaseLost = fmt.Errorf("recipes.errorLeaseLost")
+
+// ErrorInvalidTTL is returned when time-to-live for leases is shorter than
+// MinTTL.
+var ErrorInvalidTTL = fmt.Errorf("recipes.errorInvalidTTL")
+
+// MinTTL is the shortest time-to-live for leases granted by recipes.
+const MinTTL = 100 * time.Millisecond
+
+// Client to use recipes on a fail-safe dictionary.
+type Client struct {
+	serverAddr string
+	ttl        time.Duration
+}
+
+// NewClient return a new instance of recipe client, ttl is the
+// time-to-live for leases granted by recipes.
+func NewClient(serverAddr string, ttl time.Duration) (*Client, error) {
+	if ttl < MinTTL {
+		return nil, ErrorInvalidTTL
+	}
+	return &Client{serverAddr: serverAddr, ttl: ttl}, nil
+}
+
+// session holds a lease, kept alive in background until it is closed or
+// the lease is lost.
+type session struct {
+	leaseID int64
+	client  *failsafe.SafeDictClient
+	quit    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// newSession grant a lease and keep it alive in background. SafeDictClient
+// is not safe for concurrent use, hence every session uses its own.
+func (c *Client) newSession() (*session, error) {
+	client := failsafe.NewSafeDictClient(c.serverAddr)
+	leaseID, err := client.GrantLease(c.ttl)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		leaseID: leaseID,
+		client:  client,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.keepAlive(failsafe.NewSafeDictClient(c.serverAddr), c.ttl)
+	return s, nil
+}
+
+// keepAlive lease every third of its ttl. Failed keep-alives are retried
+// until the lease is past its ttl, when the lease is given up as lost.
+func (s *session) keepAlive(client *failsafe.SafeDictClient, ttl time.Duration) {
+	defer close(s.done)
+
+	interval := ttl / 3
+	deadline := time.Now().Add(ttl)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-timer.C:
+		}
+		_, err := client.KeepAlive(s.leaseID)
+		switch {
+		case err == nil:
+			deadline = time.Now().Add(ttl)
+			timer.Reset(interval)
+
+		case err == failsafe.ErrorLeaseNotFound:
+			return
+
+		default: // retry transient errors.
+			retry := interval / 4
+			if time.Now().Add(retry).After(deadline) {
+				return
+			}
+			timer.Reset(retry)
+		}
+	}
+}
+
+// close the session and revoke its lease, fields attached to the lease
+// are deleted.
+func (s *session) close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.quit)
+		<-s.done
+		_, err = s.client.Revoke(s.leaseID)
+	})
+	return err
+}
+
+// makeParents create missing parent fields of path as empty properties.
+func makeParents(client *failsafe.SafeDictClient, path string) error {
+	parts := strings.Split(path, "/")
+	for i := 2; i < len(parts); i++ {
+		parent := strings.Join(parts[:i], "/")
+		_, _, err := client.Txn().
+			If(failsafe.CompareMissing(parent)).
+			Then(failsafe.OpSet(parent, map[string]interface{}{})).
+			Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package recipes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prataprc/go-failsafe"
+)
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient(servAddr, time.Nanosecond); err != ErrorInvalidTTL {
+		t.Fatal("expected ErrorInvalidTTL", err)
+	} else if _, err := NewClient(servAddr, MinTTL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	var mu sync.Mutex
+	var keepalives int
+	recovering, leaseNotFound := true, false
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set(failsafe.HttpHdrNameLeaderAddr, server.URL)
+			if req.Method == "HEAD" {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			keepalives++
+			switch {
+			case leaseNotFound:
+				w.Write([]byte(`{"err": "lease", "code": "lease-not-found"}`))
+			case recovering && keepalives <= 2:
+				w.Write([]byte(`{"err": "timeout", "code": "timeout"}`))
+			case recovering:
+				w.Write([]byte(`{"ttl": 0.3, "err": "", "code": ""}`))
+			default:
+				w.Write([]byte(`{"err": "timeout", "code": "timeout"}`))
+			}
+		}))
+	defer server.Close()
+
+	newSession := func() *session {
+		s := &session{leaseID: 1, quit: make(chan struct{}), done: make(chan struct{})}
+		go s.keepAlive(failsafe.NewSafeDictClient(server.URL), 300*time.Millisecond)
+		return s
+	}
+
+	// transient failures are retried within ttl.
+	s := newSession()
+	select {
+	case <-s.done:
+		t.Fatal("lease lost on transient failure")
+	case <-time.After(500 * time.Millisecond):
+	}
+	mu.Lock()
+	if keepalives < 4 {
+		t.Fatal("expected keep alive to be retried", keepalives)
+	}
+	recovering = false
+	mu.Unlock()
+
+	// lease is lost once ttl is past.
+	select {
+	case <-s.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected lease to be lost")
+	}
+
+	mu.Lock()
+	leaseNotFound = true
+	mu.Unlock()
+	s = newSession()
+	select {
+	case <-s.done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("expected lease to be lost")
+	}
+}
@@ -0,0 +1,34 @@
+package failsafe
+
+import (
+	"encoding/json"
+
+	"github.com/goraft/raft"
+)
+
+// RestoreCommand to replace an empty SafeDict with a backup.
+type RestoreCommand struct {
+	Data json.RawMessage `json:"data"`
+}
+
+// NewRestoreCommand creates a new instance of RestoreCommand.
+func NewRestoreCommand(data []byte) *RestoreCommand {
+	return &RestoreCommand{data}
+}
+
+// CommandName implements raft.Command interface.
+func (c *RestoreCommand) CommandName() string {
+	return "restore"
+}
+
+// Apply implements raft.CommandApply interface.
+func (c *RestoreCommand) Apply(context raft.Context) (interface{}, error) {
+	s := context.Server().Context().(*Server)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
+	nextCAS, err := s.db.Restore(c.Data)
+	return nextCAS, err
+}
@@ -0,0 +1,201 @@
+// Per field revisions for SafeDict.
+//
+// Every field in the dictionary, addressed by its jsonpointer, remembers the
+// CAS at which it was created and the CAS at which it was last modified.
+// Modifying a field also modifies all its parent fields, hence revision of
+// the root is the dictionary's CAS. A write guarded by CAS succeeds if the
+// field was not modified after that CAS, hence unrelated writes to the
+// dictionary do not invalidate the CAS.
+//
+// Revisions are kept only for fields that were modified, or created, after
+// their parent was created. Other fields were created along with their
+// nearest parent that has a revision and were not modified since. Writes
+// compare old and new values only for the fields they touched, values
+// shared by old and new versions of the dictionary are not compared.
+
+package failsafe
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Revision of a field in SafeDict, in terms of dictionary's CAS.
+type Revision struct {
+	Create float64 `json:"create"` // CAS at which field was created.
+	Modify float64 `json:"modify"` // CAS at which field was last modified.
+}
+
+// revisions for fields in SafeDict, indexed by jsonpointer.
+type revisions map[string]Revision
+
+// newRevisions for all fields in doc, created at CAS.
+func newRevisions(doc map[string]interface{}, CAS float64) revisions {
+	revs := make(revisions)
+	if doc != nil {
+		revs[""] = Revision{Create: CAS, Modify: CAS}
+	}
+	return revs
+}
+
+// lookup revision of the field at path, field shall be present. Fields
+// without a revision share the create revision of their nearest parent.
+func (revs revisions) lookup(path string) Revision {
+	if rev, ok := revs[path]; ok {
+		return rev
+	}
+	for path != "" {
+		path = path[:strings.LastIndex(path, "/")]
+		if rev, ok := revs[path]; ok {
+			return Revision{Create: rev.Create, Modify: rev.Create}
+		}
+	}
+	return Revision{}
+}
+
+// update revisions for field at path, whose value is changing from oldv to
+// newv at CAS, ok flags tell whether the field is present or not.
+func (revs revisions) update(
+	path string, oldv interface{}, oldok bool, newv interface{}, newok bool,
+	CAS float64) {
+
+	if !revs.diff(path, oldv, oldok, newv, newok, CAS) || path == "" {
+		return
+	}
+	// modify parents, except the root whose revision is dictionary's CAS.
+	parts := parseJSONPointer(path)
+	for i := 1; i < len(parts); i++ {
+		parent := encodeJSONPointer(parts[:i])
+		rev := revs.lookup(parent)
+		rev.Modify = CAS
+		revs[parent] = rev
+	}
+}
+
+// diff old value with new value for field at path and its children,
+// revisions are updated for fields that are created, modified or removed.
+// Return true if field is changed.
+func (revs revisions) diff(
+	path string, oldv interface{}, oldok bool, newv interface{}, newok bool,
+	CAS float64) bool {
+
+	switch {
+	case !oldok && !newok:
+		return false
+	case !oldok: // children are created along with the field.
+		revs[path] = Revision{Create: CAS, Modify: CAS}
+		return true
+	case !newok:
+		revs.remove(path, oldv)
+		return true
+	case sameJSON(oldv, newv):
+		return false
+	}
+
+	changed := false
+	oldm, oldism := oldv.(map[string]interface{})
+	newm, newism := newv.(map[string]interface{})
+	olda, oldisa := oldv.([]interface{})
+	newa, newisa := newv.([]interface{})
+	switch {
+	case oldism && newism:
+		for key, value := range oldm {
+			nvalue, ok := newm[key]
+			childpath := path + encodeJSONPointer([]string{key})
+			if revs.diff(childpath, value, true, nvalue, ok, CAS) {
+				changed = true
+			}
+		}
+		for key, value := range newm {
+			if _, ok := oldm[key]; !ok {
+				childpath := path + encodeJSONPointer([]string{key})
+				revs.diff(childpath, nil, false, value, true, CAS)
+				changed = true
+			}
+		}
+
+	case oldisa && newisa:
+		for i := 0; i < len(olda) || i < len(newa); i++ {
+			var ovalue, nvalue interface{}
+			if i < len(olda) {
+				ovalue = olda[i]
+			}
+			if i < len(newa) {
+				nvalue = newa[i]
+			}
+			childpath := path + "/" + strconv.Itoa(i)
+			ok := revs.diff(childpath, ovalue, i < len(olda), nvalue, i < len(newa), CAS)
+			if ok {
+				changed = true
+			}
+		}
+
+	default: // children of old value are removed, new ones are created.
+		revs.removeChildren(path, oldv)
+		revs.createChildren(path, newv, CAS)
+		changed = oldism || oldisa || newism || newisa || !jsonEqual(oldv, newv)
+	}
+
+	if changed {
+		rev := revs.lookup(path)
+		rev.Modify = CAS
+		revs[path] = rev
+	}
+	return changed
+}
+
+// remove revisions of field at path, whose value is value, and its
+// children.
+func (revs revisions) remove(path string, value interface{}) {
+	delete(revs, path)
+	revs.removeChildren(path, value)
+}
+
+func (revs revisions) removeChildren(path string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, x := range v {
+			revs.remove(path+encodeJSONPointer([]string{key}), x)
+		}
+	case []interface{}:
+		for i, x := range v {
+			revs.remove(path+"/"+strconv.Itoa(i), x)
+		}
+	}
+}
+
+// createChildren of value at CAS, their children are created along with
+// them.
+func (revs revisions) createChildren(path string, value interface{}, CAS float64) {
+	rev := Revision{Create: CAS, Modify: CAS}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key := range v {
+			revs[path+encodeJSONPointer([]string{key})] = rev
+		}
+	case []interface{}:
+		for i := range v {
+			revs[path+"/"+strconv.Itoa(i)] = rev
+		}
+	}
+}
+
+// sameJSON return true if a and b are the same value, containers are
+// compared by reference, as unmodified values are shared between versions
+// of the dictionary, and scalars by value.
+func sameJSON(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		return ok && reflect.ValueOf(x).Pointer() == reflect.ValueOf(y).Pointer()
+	case []interface{}:
+		y, ok := b.([]interface{})
+		return ok && len(x) == len(y) && (len(x) == 0 || &x[0] == &y[0])
+	case nil, bool, string, float64:
+		if reflect.TypeOf(a) == reflect.TypeOf(b) {
+			return a == b
+		}
+	}
+	return false
+}
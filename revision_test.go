@@ -0,0 +1,174 @@
+package failsafe
+
+import (
+	"testing"
+)
+
+func TestRevisions(t *testing.T) {
+	data := `{"a": {"b": 1, "c": [1, 2]}, "d": "x"}`
+	sd, err := NewSafeDict([]byte(data), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRev := func(path string, ref Revision) {
+		if _, _, rev, err := sd.GetRev(path); err != nil {
+			t.Fatal(path, err)
+		} else if rev != ref {
+			t.Fatalf("failed revision for %q: %v, expected %v", path, rev, ref)
+		}
+	}
+	for _, path := range []string{"", "/a", "/a/b", "/a/c", "/a/c/1", "/d"} {
+		checkRev(path, Revision{1, 1})
+	}
+
+	// modify a field, its parents are modified too.
+	if _, err := sd.Set("/a/b", float64(2), nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	checkRev("", Revision{1, 2})
+	checkRev("/a", Revision{1, 2})
+	checkRev("/a/b", Revision{1, 2})
+	checkRev("/a/c", Revision{1, 1})
+
+	// setting the same value does not modify the field.
+	if _, err := sd.Set("/d", "x", nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	checkRev("/d", Revision{1, 1})
+
+	// replace an array, removed elements lose their revisions.
+	if _, err := sd.Set("/a/c", []interface{}{float64(1)}, nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := sd.GetRev("/a/c/1"); err != ErrorInvalidPath {
+		t.Fatal("expected ErrorInvalidPath", err)
+	}
+	checkRev("/a/c/0", Revision{1, 1})
+	checkRev("/a/c", Revision{1, 4})
+	checkRev("/a", Revision{1, 4})
+
+	// modify an array element.
+	if _, err := sd.Set("/a/c/0", float64(3), nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	checkRev("/a/c/0", Revision{1, 5})
+	checkRev("/a/c", Revision{1, 5})
+
+	// create a field, its children are created along with it.
+	if _, err := sd.Set("/e", map[string]interface{}{"f": "y"}, nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	checkRev("/e", Revision{6, 6})
+	checkRev("/e/f", Revision{6, 6})
+	checkRev("/a", Revision{1, 5})
+
+	// delete a field and create it again.
+	if _, err := sd.Delete("/e", nullCAS); err != nil {
+		t.Fatal(err)
+	} else if _, _, _, err := sd.GetRev("/e/f"); err != ErrorInvalidPath {
+		t.Fatal("expected ErrorInvalidPath", err)
+	}
+	if _, err := sd.Set("/e", map[string]interface{}{"g": "z"}, nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	checkRev("/e", Revision{8, 8})
+	checkRev("/e/g", Revision{8, 8})
+	checkRev("", Revision{1, 8})
+
+	// merge touches only the merged fields.
+	patch := map[string]interface{}{"b": float64(5), "h": "w"}
+	if _, err := sd.Merge("/a", patch, nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	checkRev("/a", Revision{1, 9})
+	checkRev("/a/b", Revision{1, 9})
+	checkRev("/a/h", Revision{9, 9})
+	checkRev("/a/c", Revision{1, 5})
+
+	// patch touches only the patched fields.
+	ops := []PatchOp{
+		{Op: "add", Path: "/a/c/-", Value: float64(4)},
+		{Op: "remove", Path: "/a/h"},
+	}
+	if _, err := sd.Patch(ops, nullCAS); err != nil {
+		t.Fatal(err)
+	}
+	checkRev("/a/c", Revision{1, 10})
+	checkRev("/a/c/1", Revision{10, 10})
+	checkRev("/a/b", Revision{1, 9})
+	checkRev("/d", Revision{1, 1})
+}
+
+func TestRevisionCAS(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": 1, "b": 2}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, CAS, _, _ := sd.GetRev("/a")
+
+	// unrelated writes do not invalidate CAS.
+	if _, err := sd.Set("/b", float64(3), CAS); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sd.Set("/a", float64(4), CAS); err != nil {
+		t.Fatal(err)
+	}
+	// a write to the field invalidates CAS.
+	if _, err := sd.Set("/a", float64(5), CAS); err != ErrorInvalidCAS {
+		t.Fatal("expected ErrorInvalidCAS", err)
+	}
+	if _, err := sd.Delete("/a", CAS); err != ErrorInvalidCAS {
+		t.Fatal("expected ErrorInvalidCAS", err)
+	}
+	if _, err := sd.Delete("/a", sd.GetCAS()+1); err != ErrorInvalidCAS {
+		t.Fatal("expected ErrorInvalidCAS for future CAS", err)
+	}
+	_, _, rev, _ := sd.GetRev("/a")
+	if _, err := sd.Delete("/a", rev.Modify); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := &Txn{
+		Compare: []TxnCompare{CompareVersion("/b", 2), CompareVersion("/a", 0)},
+		Then:    []TxnOp{OpSet("/a", "created")},
+	}
+	if result, err := sd.Txn(txn); err != nil {
+		t.Fatal(err)
+	} else if result.Succeeded == false {
+		t.Fatal("expected transaction to succeed")
+	} else if _, _, rev, _ := sd.GetRev("/a"); rev != (Revision{5, 5}) {
+		t.Fatal("failed revision on txn", rev)
+	}
+}
+
+func TestSaveRestoreRevisions(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": 1, "b": 2}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.Set("/a", float64(10), nullCAS)
+	data, err := sd.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd1, _ := NewSafeDict(nil, true)
+	if err := sd1.Recovery(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"", "/a", "/b"} {
+		_, _, ref, _ := sd.GetRev(path)
+		if _, _, rev, err := sd1.GetRev(path); err != nil {
+			t.Fatal(err)
+		} else if rev != ref {
+			t.Fatal("failed save / recovery for revisions", path, rev)
+		}
+	}
+
+	// snapshots without revisions.
+	sd2, _ := NewSafeDict(nil, true)
+	if err := sd2.Recovery([]byte(`{"m": {"a": 1}, "CAS": 7}`)); err != nil {
+		t.Fatal(err)
+	} else if _, _, rev, _ := sd2.GetRev("/a"); rev != (Revision{7, 7}) {
+		t.Fatal("failed recovery without revisions", rev)
+	}
+}
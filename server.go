@@ -11,13 +11,28 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
+// ErrorInvalidConsistency for unknown read consistency level.
+var ErrorInvalidConsistency = fmt.Errorf("failsafe.errorInvalidConsistency")
+
+// ErrorExistingLog when joining a cluster with a non-empty raft log.
+var ErrorExistingLog = fmt.Errorf("failsafe.errorExistingLog")
+
 // RegisterCommands with raft for failsafe package.
 func RegisterCommands() {
 	raft.RegisterCommand(&SetCommand{})
 	raft.RegisterCommand(&DeleteCommand{})
+	raft.RegisterCommand(&PatchCommand{})
+	raft.RegisterCommand(&MergeCommand{})
+	raft.RegisterCommand(&TxnCommand{})
+	raft.RegisterCommand(&CompactCommand{})
+	raft.RegisterCommand(&GrantLeaseCommand{})
+	raft.RegisterCommand(&RevokeLeaseCommand{})
+	raft.RegisterCommand(&RestoreCommand{})
 }
 
 // Server is a combination of the Raft server and HTTP server which acts as
@@ -31,9 +46,44 @@ type Server struct {
 	mux         raft.HTTPMuxer // mux can be used to chain HTTP handlers.
 	raftServer  raft.Server
 	db          *SafeDict
+	// lease expiry, tracked only by leader.
+	leaseMu        sync.Mutex
+	leaseDeadlines map[int64]time.Time
+	// forwarding of writes from followers to leader.
+	forwarding string
+	// transfer leadership before stopping.
+	transferOnStop bool
+	campaignMu     sync.Mutex
+	campaigning    bool // election timeout is lowered for campaign.
+	// learner replicates the dictionary from leader without voting, refer
+	// learner.go.
+	learner      bool
+	learnerMu    sync.Mutex
+	leaderInfo   [2]string // leader as known by learner.
+	bootstrapped bool      // learner has bootstrapped from leader.
+	learnCancel  func()
+	learnDone    chan struct{}
+	promoteMu    sync.Mutex // serialises Promote.
+	// learners registered with this server, tracked only by leader.
+	learners map[string]Member
+	// snapshot policy, snapMu serialises snapshots.
+	snapMu     sync.Mutex
+	snapPolicy SnapshotPolicy
+	// state of last snapshot, snapInfoMu is not held while taking snapshot.
+	snapInfoMu sync.Mutex
+	snapIndex  uint64
+	snapTime   time.Time
 	// misc.
-	logPrefix string
-	stats     Stats
+	logPrefix    string
+	statsMu      sync.Mutex
+	stats        Stats
+	appliedIndex uint64
+	recovered    bool       // recovered from snapshot since last commit.
+	applyMu      sync.Mutex // serialises applying commands and backups.
+	applyIndex   uint64     // index of the last applied command.
+	metrics      *metrics
+	quitch       chan struct{}
+	stopOnce     sync.Once
 }
 
 type Context struct {
@@ -55,7 +105,13 @@ func NewServer(name, path, listernAddr string, mux raft.HTTPMuxer) (s *Server, e
 		mux:         mux,
 		logPrefix:   fmt.Sprintf("[failsafe.%v]", name),
 		stats:       NewStats(),
+		metrics:     newMetrics(),
+		quitch:      make(chan struct{}),
+		forwarding:  ForwardProxy,
+		snapPolicy:  DefaultSnapshotPolicy,
 	}
+	s.leaseDeadlines = make(map[int64]time.Time)
+	s.learners = make(map[string]Member)
 
 	if s.name == "" {
 		nameFile := filepath.Join(path, "name")
@@ -65,14 +121,16 @@ func NewServer(name, path, listernAddr string, mux raft.HTTPMuxer) (s *Server, e
 			s.name = fmt.Sprintf("%07x", rand.Int())[0:7]
 			err := ioutil.WriteFile(nameFile, []byte(s.name), 0644)
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
 		}
 	}
 
 	if s.db, err = NewSafeDict(nil, true); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	s.db.SetHistorySize(defaultHistorySize)
+	s.db.SetVersionWindow(defaultVersionWindow)
 	return s, nil
 }
 
@@ -82,9 +140,28 @@ func SetLogLevel(level int) {
 	raft.SetLogLevel(level)
 }
 
-// GetStats return statistics for this server node.
+// GetStats return a copy of statistics for this server node.
 func (s *Server) GetStats() Stats {
-	return s.stats
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	stats := make(Stats)
+	for key, value := range s.stats {
+		stats[key] = value
+	}
+	return stats
+}
+
+func (s *Server) incrStat(key string) {
+	s.statsMu.Lock()
+	s.stats[key] = s.stats[key].(int) + 1
+	s.statsMu.Unlock()
+}
+
+func (s *Server) setStat(key string, value interface{}) {
+	s.statsMu.Lock()
+	s.stats[key] = value
+	s.statsMu.Unlock()
 }
 
 // GetRaftserver return raft server instance associated with this server node.
@@ -101,65 +178,138 @@ func (s *Server) RemovePeers() {
 
 // Install to be called after NewServer(). This call will initialize and start
 // a raft-server, start a cluster / join a cluster, subscribe http-handlers to
-// muxer, add raft event callbacks.
+// muxer, add raft event callbacks. On error, raft-server is stopped and
+// application can retry with a new Server and a new muxer.
 func (s *Server) Install(leader string) (err error) {
 	// Initialize and start Raft server.
 	trans := raft.NewHTTPTransporter("/raft", 200*time.Millisecond)
 	connStr := s.connectionString()
-	s.raftServer, err = raft.NewServer(s.name, s.path, trans, s.db, s, connStr)
+	sm := &stateMachine{s: s}
+	s.raftServer, err = raft.NewServer(s.name, s.path, trans, sm, s, connStr)
 	if err != nil {
-		log.Fatalf("%v, %v\n", s.logPrefix, err)
+		return err
 	}
 	name := s.raftServer.Name()
 	tracef("%s, initializing Raft Server\n", s.logPrefix)
 
 	trans.Install(s.raftServer, s)
+	s.installProbes()
+
+	if s.learner {
+		return s.installLearner(leader)
+	}
 
 	// Read snapshot.
-	if s.raftServer.LoadSnapshot() != nil {
+	if err := s.raftServer.LoadSnapshot(); err != nil {
 		tracef("%v, loadingSnapshot %v\n", s.logPrefix, err)
+	} else {
+		s.syncAppliedIndex()
 	}
 	s.RemovePeers()
-	s.raftServer.Start()
+	if err = s.raftServer.Start(); err != nil {
+		return err
+	}
 
 	if leader != "" { // Join to leader if specified.
 		tracef("%v, attempting to join leader %q\n", s.logPrefix, leader)
 		if !s.raftServer.IsLogEmpty() {
-			log.Fatalf("%v, cannot join with an existing log\n", s.logPrefix)
+			s.raftServer.Stop()
+			return ErrorExistingLog
 		}
-		if err := s.selfJoin(leader); err != nil {
-			log.Fatalf("%v, %v\n", s.logPrefix, err)
+		if err = s.selfJoin(leader); err != nil {
+			s.raftServer.Stop()
+			return err
 		}
 
 	} else if s.raftServer.IsLogEmpty() {
 		// Initialize the server by joining itself.
 		tracef("%v, initializing new cluster\n", s.logPrefix)
-		_, err := s.raftServer.Do(&raft.DefaultJoinCommand{
+		_, err = s.raftServer.Do(&raft.DefaultJoinCommand{
 			Name:             s.raftServer.Name(),
 			ConnectionString: s.connectionString(),
 		})
 		if err != nil {
-			log.Fatalf("%v, %v\n", s.logPrefix, err)
+			s.raftServer.Stop()
+			return err
 		}
 
 	} else {
 		tracef("%v, recovered from log\n", name)
 	}
 
-	s.mux.HandleFunc("/dict", s.dbHandler)
-	s.mux.HandleFunc("/join", s.joinHandler)
-	s.mux.HandleFunc("/leave", s.leaveHandler)
-
+	s.installHandlers()
 	s.AddEventListeners()
+	s.startBackground()
 	return
 }
 
+// installHandlers subscribes http-handlers to muxer.
+func (s *Server) installHandlers() {
+	handlers := []struct {
+		pattern string
+		handler http.HandlerFunc
+	}{
+		{"/dict", s.forwardHandler(s.dbHandler)},
+		{"/dict/", s.forwardHandler(s.resourceHandler)},
+		{"/dict/txn", s.forwardHandler(s.txnHandler)},
+		{"/dict/changes", s.changesHandler},
+		{"/dict/compact", s.forwardHandler(s.compactHandler)},
+		{"/join", s.joinHandler},
+		{"/leave", s.leaveHandler},
+		{"/lease/grant", s.forwardHandler(s.leaseHandler)},
+		{"/lease/keepalive", s.forwardHandler(s.leaseHandler)},
+		{"/lease/revoke", s.forwardHandler(s.leaseHandler)},
+		{"/admin/backup", s.backupHandler},
+		{"/admin/restore", s.forwardHandler(s.restoreHandler)},
+		{"/admin/members", s.leaderHandler(s.membersHandler)},
+		{"/admin/promote", s.promoteHandler},
+		{"/admin/transfer-leader", s.leaderHandler(s.transferHandler)},
+		{"/admin/campaign", s.campaignHandler},
+	}
+	for _, x := range handlers {
+		s.mux.HandleFunc(x.pattern, s.instrument(x.pattern, x.handler))
+	}
+	// long lived requests are not instrumented.
+	s.mux.HandleFunc("/dict/watch", s.watchHandler)
+}
+
+// installProbes subscribes probe handlers to muxer, before raft-server is
+// started. Probe requests are not instrumented.
+func (s *Server) installProbes() {
+	s.mux.HandleFunc("/health", s.healthHandler)
+	s.mux.HandleFunc("/ready", s.readyHandler)
+	s.mux.HandleFunc("/status", s.statusHandler)
+	s.mux.HandleFunc("/metrics", s.metricsHandler)
+}
+
+// startBackground routines, once raft-server is started.
+func (s *Server) startBackground() {
+	s.syncAppliedIndex()
+	s.setSnapshotInfo(s.raftServer.CommitIndex(), time.Now())
+	go s.expireLeases()
+	go s.snapshotter()
+}
+
 // HandleFunc callback for raft.
 func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
 	s.mux.HandleFunc(pattern, handler)
 }
 
+// SetForwarding of writes received by followers, one of ForwardProxy,
+// ForwardRedirect or ForwardNone, default is ForwardProxy. Shall be called
+// before Install.
+func (s *Server) SetForwarding(forwarding string) {
+	s.forwarding = forwarding
+}
+
+// GetLeader return leader's name and connection string, empty strings if
+// leader is not known.
 func (s *Server) GetLeader() [2]string {
+	if s.isLearner() {
+		s.learnerMu.Lock()
+		defer s.learnerMu.Unlock()
+		return s.leaderInfo
+	}
 	if name := s.raftServer.Leader(); name != "" {
 		if name == s.raftServer.Name() {
 			return [2]string{name, s.connectionString()}
@@ -170,12 +320,52 @@ func (s *Server) GetLeader() [2]string {
 	return [2]string{"", ""}
 }
 
+// SyncRead waits until the local dictionary can serve reads with the
+// specified consistency level, an empty level is same as ConsistencyStale.
+func (s *Server) SyncRead(consistency string) error {
+	switch consistency {
+	case "", ConsistencyStale:
+		return nil
+
+	case ConsistencyLeader:
+		if s.raftServer.State() != raft.Leader {
+			return raft.NotLeaderError
+		}
+		return nil
+
+	case ConsistencyLinearizable:
+		_, err := s.raftServer.Do(&raft.NOPCommand{})
+		return err
+	}
+	return ErrorInvalidConsistency
+}
+
+// DBGetConsistent is same as DBGetRev, with reads served at the specified
+// consistency level.
+func (s *Server) DBGetConsistent(path, consistency string) (value interface{}, CAS float64, rev Revision, err error) {
+	if err = s.SyncRead(consistency); err != nil {
+		return nil, nullCAS, rev, err
+	}
+	return s.db.GetRev(path)
+}
+
 // DBGet field value located by `path` jsonpointer, full json-pointer spec is
-// allowed.
+// allowed. Value is read from the local dictionary, refer DBGetConsistent
+// for stronger consistency.
 func (s *Server) DBGet(path string) (value interface{}, CAS float64, err error) {
 	return s.db.Get(path)
 }
 
+// DBGetRev is same as DBGet, additionally returns the revision of the field.
+func (s *Server) DBGetRev(path string) (value interface{}, CAS float64, rev Revision, err error) {
+	return s.db.GetRev(path)
+}
+
+// DBGetAt field value located by `path` jsonpointer, as it was at CAS.
+func (s *Server) DBGetAt(path string, CAS float64) (value interface{}, err error) {
+	return s.db.GetAt(path, CAS)
+}
+
 // DBSet value at the specified path, full json-pointer spec. is allowed. CAS
 // is ignored.
 func (s *Server) DBSet(path string, value interface{}) (nextCAS float64, err error) {
@@ -187,7 +377,7 @@ func (s *Server) DBSet(path string, value interface{}) (nextCAS float64, err err
 }
 
 // DBSetCAS value at the specified path with matching CAS, full json-pointer
-// spec. is allowed.
+// spec. is allowed. CAS matches if field is not modified after CAS.
 func (s *Server) DBSetCAS(path string, value interface{}, CAS float64) (nextCAS float64, err error) {
 	val, err := s.raftServer.Do(NewSetCommand(path, value, CAS))
 	if err == nil {
@@ -196,6 +386,26 @@ func (s *Server) DBSetCAS(path string, value interface{}, CAS float64) (nextCAS
 	return nullCAS, err
 }
 
+// DBSetLease value at the specified path and attach the field to lease,
+// full json-pointer spec. is allowed. CAS is ignored.
+func (s *Server) DBSetLease(path string, value interface{}, leaseID int64) (nextCAS float64, err error) {
+	val, err := s.raftServer.Do(NewSetLeaseCommand(path, value, nullCAS, leaseID))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
+// DBSetLeaseCAS value at the specified path with matching CAS and attach
+// the field to lease, full json-pointer spec. is allowed.
+func (s *Server) DBSetLeaseCAS(path string, value interface{}, CAS float64, leaseID int64) (nextCAS float64, err error) {
+	val, err := s.raftServer.Do(NewSetLeaseCommand(path, value, CAS, leaseID))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
 // DBDelete value at the specified path, last segment shall always index
 // into json property. CAS is ignored.
 func (s *Server) DBDelete(path string) (nextCAS float64, err error) {
@@ -207,7 +417,8 @@ func (s *Server) DBDelete(path string) (nextCAS float64, err error) {
 }
 
 // DBDeleteCAS value at the specified path with matching CAS, last segment
-// shall always index into json property.
+// shall always index into json property. CAS matches if field is not
+// modified after CAS.
 func (s *Server) DBDeleteCAS(path string, CAS float64) (nextCAS float64, err error) {
 	val, err := s.raftServer.Do(NewDeleteCommand(path, CAS))
 	if err == nil {
@@ -216,14 +427,195 @@ func (s *Server) DBDeleteCAS(path string, CAS float64) (nextCAS float64, err err
 	return nullCAS, err
 }
 
-// Stop will stop the server and persist the dictionary on the disk.
+// DBPatch applies RFC 6902 JSON Patch document atomically. CAS is ignored.
+func (s *Server) DBPatch(ops []PatchOp) (nextCAS float64, err error) {
+	val, err := s.raftServer.Do(NewPatchCommand(ops, nullCAS))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
+// DBPatchCAS applies RFC 6902 JSON Patch document atomically with matching
+// CAS.
+func (s *Server) DBPatchCAS(ops []PatchOp, CAS float64) (nextCAS float64, err error) {
+	val, err := s.raftServer.Do(NewPatchCommand(ops, CAS))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
+// DBMerge applies RFC 7386 JSON Merge Patch on the value at the specified
+// path, full json-pointer spec. is allowed. CAS is ignored.
+func (s *Server) DBMerge(path string, patch interface{}) (nextCAS float64, err error) {
+	val, err := s.raftServer.Do(NewMergeCommand(path, patch, nullCAS))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
+// DBMergeCAS applies RFC 7386 JSON Merge Patch on the value at the specified
+// path with matching CAS, full json-pointer spec. is allowed.
+func (s *Server) DBMergeCAS(path string, patch interface{}, CAS float64) (nextCAS float64, err error) {
+	val, err := s.raftServer.Do(NewMergeCommand(path, patch, CAS))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
+// DBTxn applies a transaction, compare conditions are evaluated and
+// operations are applied atomically.
+func (s *Server) DBTxn(txn *Txn) (result TxnResult, err error) {
+	val, err := s.raftServer.Do(NewTxnCommand(txn))
+	if err == nil {
+		return val.(TxnResult), err
+	}
+	return TxnResult{CAS: nullCAS}, err
+}
+
+// Watch for changes to fields under jsonpointer prefix, that happened after
+// fromCAS. Changes are delivered on returned channel until cancel is called.
+func (s *Server) Watch(prefix string, fromCAS float64) (ch <-chan Change, cancel func(), err error) {
+	return s.db.Watch(prefix, fromCAS)
+}
+
+// DBCompact older versions of the dictionary upto CAS, on all nodes.
+func (s *Server) DBCompact(CAS float64) (nextCAS float64, err error) {
+	val, err := s.raftServer.Do(NewCompactCommand(CAS))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
+// GrantLease with time-to-live, fields attached to the lease are deleted
+// when the lease expires or is revoked.
+func (s *Server) GrantLease(ttl time.Duration) (leaseID int64, err error) {
+	val, err := s.raftServer.Do(NewGrantLeaseCommand(ttl))
+	if err != nil {
+		return 0, err
+	}
+	leaseID = val.(int64)
+	s.leaseMu.Lock()
+	s.leaseDeadlines[leaseID] = time.Now().Add(ttl)
+	s.leaseMu.Unlock()
+	return leaseID, nil
+}
+
+// KeepAlive lease for another time-to-live period, shall be called on the
+// leader.
+func (s *Server) KeepAlive(leaseID int64) (ttl time.Duration, err error) {
+	if s.raftServer.State() != raft.Leader {
+		return 0, raft.NotLeaderError
+	}
+	if ttl, err = s.db.GetLeaseTTL(leaseID); err != nil {
+		return 0, err
+	}
+	s.leaseMu.Lock()
+	s.leaseDeadlines[leaseID] = time.Now().Add(ttl)
+	s.leaseMu.Unlock()
+	return ttl, nil
+}
+
+// Revoke lease and delete all fields attached to it.
+func (s *Server) Revoke(leaseID int64) (nextCAS float64, err error) {
+	val, err := s.raftServer.Do(NewRevokeLeaseCommand(leaseID))
+	if err == nil {
+		return val.(float64), err
+	}
+	return nullCAS, err
+}
+
+// SetHistorySize to remember upto `size` recent changes to the dictionary.
+func (s *Server) SetHistorySize(size int) {
+	s.db.SetHistorySize(size)
+}
+
+// SetVersionWindow to retain older versions of the dictionary for `window`
+// recent CAS.
+func (s *Server) SetVersionWindow(window float64) {
+	s.db.SetVersionWindow(window)
+}
+
+// GetChangesSince return every change to the dictionary after CAS.
+func (s *Server) GetChangesSince(CAS float64) ([]Change, error) {
+	return s.db.GetChangesSince(CAS)
+}
+
+// Stop will stop the server and persist the dictionary on the disk. If
+// SetTransferOnStop is enabled, leader first transfers its leadership.
+// Calling Stop more than once is a no-op.
 func (s *Server) Stop() (err error) {
+	s.stopOnce.Do(func() {
+		if s.transferOnStop && !s.isLearner() {
+			s.transferBeforeStop()
+		}
+		err = s.stop()
+	})
+	return err
+}
+
+func (s *Server) stop() (err error) {
+	close(s.quitch)
+	if s.isLearner() {
+		s.stopLearning()
+		return nil
+	}
+	// raft-server is stopped even if snapshot fails, as Stop cannot be
+	// retried, snapshotter shall not use raft-server meanwhile.
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	defer s.raftServer.Stop()
 	s.raftServer.FlushCommitIndex()
-	if err = s.raftServer.TakeSnapshot(); err != nil {
-		return
+	return s.takeSnapshot()
+}
+
+// expireLeases periodically, only leader tracks the expiry of leases and
+// revokes them through raft. Deadlines are forgotten when not a leader, so
+// that a new leader starts afresh.
+func (s *Server) expireLeases() {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case <-ticker.C:
+		}
+
+		s.leaseMu.Lock()
+		if s.raftServer.State() != raft.Leader {
+			s.leaseDeadlines = make(map[int64]time.Time)
+			s.leaseMu.Unlock()
+			continue
+		}
+		now, expired := time.Now(), make([]int64, 0)
+		ttls := s.db.GetLeases()
+		for leaseID, ttl := range ttls {
+			if deadline, ok := s.leaseDeadlines[leaseID]; !ok {
+				s.leaseDeadlines[leaseID] = now.Add(ttl)
+			} else if now.After(deadline) {
+				expired = append(expired, leaseID)
+			}
+		}
+		for leaseID := range s.leaseDeadlines {
+			if _, ok := ttls[leaseID]; !ok {
+				delete(s.leaseDeadlines, leaseID)
+			}
+		}
+		s.leaseMu.Unlock()
+
+		for _, leaseID := range expired {
+			tracef("%v, lease %v expired\n", s.logPrefix, leaseID)
+			if _, err := s.Revoke(leaseID); err != nil {
+				log.Printf("%v, revoking lease %v: %v\n", s.logPrefix, leaseID, err)
+			}
+		}
 	}
-	s.raftServer.Stop()
-	return
 }
 
 func (s *Server) selfJoin(leader string) error {
@@ -239,7 +631,11 @@ func (s *Server) selfJoin(leader string) error {
 	if err != nil {
 		return err
 	}
-	resp.Body.Close()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("joining %v: %v", leader, strings.TrimSpace(string(msg)))
+	}
 	return nil
 }
 
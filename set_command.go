@@ -4,17 +4,25 @@ import (
 	"github.com/goraft/raft"
 )
 
-// SetCommand to set value to a field in SafeDict.
+// SetCommand to set value to a field in SafeDict, optionally attaching the
+// field to a lease.
 type SetCommand struct {
 	Path  string      `json:"path"`
 	Value interface{} `json:"value"`
 	CAS   float64     `json:"CAS"`
+	Lease int64       `json:"lease,omitempty"`
 }
 
 // NewSetCommand creates a new instance of SetCommand.
 // TODO: figure out a way to resue the command, to reduce GC overhead.
 func NewSetCommand(path string, value interface{}, cas float64) *SetCommand {
-	return &SetCommand{path, value, cas}
+	return &SetCommand{path, value, cas, 0}
+}
+
+// NewSetLeaseCommand creates a new instance of SetCommand that attaches the
+// field to lease.
+func NewSetLeaseCommand(path string, value interface{}, cas float64, lease int64) *SetCommand {
+	return &SetCommand{path, value, cas, lease}
 }
 
 // CommandName implements raft.Command interface.
@@ -25,6 +33,18 @@ func (c *SetCommand) CommandName() string {
 // Apply implements raft.CommandApply interface.
 func (c *SetCommand) Apply(context raft.Context) (interface{}, error) {
 	s := context.Server().Context().(*Server)
-	nextCAS, err := s.db.Set(c.Path, c.Value, c.CAS)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
+	var nextCAS float64
+	var err error
+	if c.Lease != 0 {
+		nextCAS, err = s.db.SetLease(c.Path, c.Value, c.CAS, c.Lease)
+	} else {
+		nextCAS, err = s.db.Set(c.Path, c.Value, c.CAS)
+	}
+	s.countConflict(err)
 	return nextCAS, err
 }
@@ -0,0 +1,211 @@
+// Snapshot policy for raft log.
+//
+// Raft log grows with every command and is replayed on restart. Server
+// takes a snapshot of SafeDict in the background when any of the policy's
+// thresholds is crossed, taking a snapshot compacts the raft log upto the
+// snapshot's index.
+//
+// goraft applies commands while holding its log lock, which is also taken
+// by TakeSnapshot, hence commands cannot be held off for the whole
+// snapshot. Instead the dictionary is saved along with the index of the
+// last command applied on it, that can be later than the snapshot's index,
+// and commands replayed from the log upto that index are skipped.
+
+package failsafe
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotPolicy to decide when to take a snapshot, thresholds that are
+// zero are disabled.
+type SnapshotPolicy struct {
+	// Commits since last snapshot.
+	Commits uint64
+	// Interval since last snapshot.
+	Interval time.Duration
+	// LogSize in bytes of the raft log.
+	LogSize int64
+}
+
+// DefaultSnapshotPolicy used by Server, unless changed by
+// SetSnapshotPolicy.
+var DefaultSnapshotPolicy = SnapshotPolicy{
+	Commits:  defaultSnapshotCommits,
+	Interval: defaultSnapshotInterval,
+	LogSize:  defaultSnapshotLogSize,
+}
+
+// due return true if a snapshot shall be taken, given the number of
+// commits and time elapsed since the last snapshot and the size of raft
+// log.
+func (policy SnapshotPolicy) due(commits uint64, elapsed time.Duration, logSize int64) bool {
+	if commits == 0 {
+		return false // nothing new to snapshot.
+	} else if policy.Commits > 0 && commits >= policy.Commits {
+		return true
+	} else if policy.Interval > 0 && elapsed >= policy.Interval {
+		return true
+	} else if policy.LogSize > 0 && logSize >= policy.LogSize {
+		return true
+	}
+	return false
+}
+
+// SetSnapshotPolicy for this server, can be called any time.
+func (s *Server) SetSnapshotPolicy(policy SnapshotPolicy) {
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	s.snapPolicy = policy
+}
+
+// TakeSnapshot of the dictionary and compact the raft log.
+func (s *Server) TakeSnapshot() error {
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	return s.takeSnapshot()
+}
+
+// takeSnapshot shall be called with snapMu locked.
+func (s *Server) takeSnapshot() error {
+	start, commitIndex := time.Now(), s.raftServer.CommitIndex()
+	if err := s.raftServer.TakeSnapshot(); err != nil {
+		s.incrStat("snapshotErrors")
+		return err
+	}
+	s.setSnapshotInfo(commitIndex, time.Now())
+	s.metrics.snapshot.since(start)
+	s.incrStat("snapshotCount")
+	s.setStat("snapshotDuration", time.Since(start))
+	s.setStat("snapshotSize", latestFileSize(s.snapshotDir()))
+	return nil
+}
+
+// setSnapshotInfo of the last snapshot.
+func (s *Server) setSnapshotInfo(index uint64, t time.Time) {
+	s.snapInfoMu.Lock()
+	s.snapIndex, s.snapTime = index, t
+	s.snapInfoMu.Unlock()
+}
+
+// getSnapshotInfo return index and time of the last snapshot.
+func (s *Server) getSnapshotInfo() (index uint64, t time.Time) {
+	s.snapInfoMu.Lock()
+	defer s.snapInfoMu.Unlock()
+	return s.snapIndex, s.snapTime
+}
+
+// snapshotter takes snapshot as per the policy, until server is stopped.
+func (s *Server) snapshotter() {
+	ticker := time.NewTicker(snapshotCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quitch:
+			return
+		case <-ticker.C:
+		}
+
+		var logSize int64
+		if fi, err := os.Stat(s.raftServer.LogPath()); err == nil {
+			logSize = fi.Size()
+		}
+		s.setStat("raftLogSize", logSize)
+
+		s.snapMu.Lock()
+		select {
+		case <-s.quitch: // raft-server is stopped.
+			s.snapMu.Unlock()
+			return
+		default:
+		}
+		var commits uint64
+		snapIndex, snapTime := s.getSnapshotInfo()
+		if commitIndex := s.raftServer.CommitIndex(); commitIndex > snapIndex {
+			commits = commitIndex - snapIndex
+		}
+		elapsed := time.Since(snapTime)
+		if s.snapPolicy.due(commits, elapsed, logSize) {
+			tracef("%v, taking snapshot after %v commits, %v, %v bytes\n",
+				s.logPrefix, commits, elapsed, logSize)
+			if err := s.takeSnapshot(); err != nil {
+				debugf("%v, snapshot: %v\n", s.logPrefix, err)
+			}
+		}
+		s.snapMu.Unlock()
+	}
+}
+
+// stateMachine for raft-server, wraps the dictionary to save it along with
+// the index of the last applied command and to track recovery of
+// snapshots.
+type stateMachine struct {
+	s *Server
+}
+
+// snapshotState saved in raft snapshots.
+type snapshotState struct {
+	Index uint64          `json:"index"` // index of the last applied command.
+	Dict  json.RawMessage `json:"dict"`
+}
+
+// Save implements raft.StateMachine interface, commands are held off
+// while the dictionary is saved.
+func (sm *stateMachine) Save() ([]byte, error) {
+	sm.s.applyMu.Lock()
+	defer sm.s.applyMu.Unlock()
+
+	data, err := sm.s.db.Save()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&snapshotState{Index: sm.s.applyIndex, Dict: data})
+}
+
+// Recovery implements raft.StateMachine interface, snapshots saved without
+// index are recovered as the dictionary.
+func (sm *stateMachine) Recovery(data []byte) error {
+	var state snapshotState
+	if err := json.Unmarshal(data, &state); err != nil || state.Dict == nil {
+		state = snapshotState{Dict: data}
+	}
+	sm.s.applyMu.Lock()
+	defer sm.s.applyMu.Unlock()
+
+	if err := sm.s.db.Recovery(state.Dict); err != nil {
+		return err
+	}
+	sm.s.applyIndex = state.Index
+	sm.s.setRecovered(true)
+	return nil
+}
+
+// snapshotDir where raft saves snapshots.
+func (s *Server) snapshotDir() string {
+	return filepath.Join(s.path, "snapshot")
+}
+
+// latestFileSize return size of the most recently modified file in dir.
+func latestFileSize(dir string) int64 {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var latest os.FileInfo
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		} else if latest == nil || fi.ModTime().After(latest.ModTime()) {
+			latest = fi
+		}
+	}
+	if latest == nil {
+		return 0
+	}
+	return latest.Size()
+}
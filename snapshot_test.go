@@ -0,0 +1,151 @@
+package failsafe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotPolicy(t *testing.T) {
+	policy := SnapshotPolicy{Commits: 100, Interval: time.Minute, LogSize: 1024}
+	testcases := []struct {
+		commits uint64
+		elapsed time.Duration
+		logSize int64
+		due     bool
+	}{
+		{0, time.Hour, 1024 * 1024, false},
+		{1, time.Second, 10, false},
+		{100, time.Second, 10, true},
+		{1, time.Minute, 10, true},
+		{1, time.Second, 1024, true},
+	}
+	for _, tcase := range testcases {
+		due := policy.due(tcase.commits, tcase.elapsed, tcase.logSize)
+		if due != tcase.due {
+			t.Errorf("expected %v for %+v", tcase.due, tcase)
+		}
+	}
+	// disabled thresholds.
+	if (SnapshotPolicy{}).due(1000000, time.Hour, 1024*1024) {
+		t.Fatal("expected no snapshot for empty policy")
+	}
+}
+
+func TestLatestFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failsafe-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if size := latestFileSize(filepath.Join(dir, "missing")); size != 0 {
+		t.Fatalf("expected 0, got %v", size)
+	}
+	older, newer := filepath.Join(dir, "1_10.ss"), filepath.Join(dir, "1_20.ss")
+	if err := ioutil.WriteFile(older, make([]byte, 10), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(newer, make([]byte, 20), 0600); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(older, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if size := latestFileSize(dir); size != 20 {
+		t.Fatalf("expected 20, got %v", size)
+	}
+}
+
+func TestStopSnapshotError(t *testing.T) {
+	rs := &testRaftServer{snapshotErr: fmt.Errorf("snapshot failed")}
+	s := &Server{
+		raftServer: rs, stats: NewStats(), metrics: newMetrics(),
+		quitch: make(chan struct{}),
+	}
+	if err := s.Stop(); err != rs.snapshotErr {
+		t.Fatalf("expected %v, got %v", rs.snapshotErr, err)
+	} else if rs.stops != 1 {
+		t.Fatal("expected raft-server to be stopped", rs.stops)
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	} else if rs.stops != 1 {
+		t.Fatal("expected raft-server to be stopped once", rs.stops)
+	}
+}
+
+func TestSnapshotWhileApplying(t *testing.T) {
+	newServer := func() (*Server, *testRaftServer) {
+		sd, _ := NewSafeDict(nil, true)
+		rs := &testRaftServer{}
+		s := &Server{db: sd, raftServer: rs, stats: NewStats(), metrics: newMetrics()}
+		rs.context, rs.stateMachine = s, &stateMachine{s: s}
+		return s, rs
+	}
+	s, rs := newServer()
+
+	donech := make(chan struct{})
+	go func() {
+		defer close(donech)
+		for i := uint64(1); i <= 1000; i++ {
+			cmd := NewSetCommand(fmt.Sprintf("/f%v", i%10), i, nullCAS)
+			if _, err := cmd.Apply(&testContext{server: rs, index: i}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	// every command increments CAS, hence snapshot's CAS follows its index.
+	var state snapshotState
+	for done := false; !done; {
+		select {
+		case <-donech:
+			done = true
+		default:
+		}
+		if err := s.TakeSnapshot(); err != nil {
+			t.Fatal(err)
+		} else if err := json.Unmarshal(rs.snapshot, &state); err != nil {
+			t.Fatal(err)
+		}
+		sd, _ := NewSafeDict(nil, true)
+		if err := sd.Recovery(state.Dict); err != nil {
+			t.Fatal(err)
+		} else if CAS := sd.GetCAS(); CAS != float64(state.Index+1) {
+			t.Fatal("snapshot inconsistent with its index", state.Index, CAS)
+		}
+	}
+	if state.Index != 1000 {
+		t.Fatal("unexpected snapshot index", state.Index)
+	}
+
+	// commands replayed upto the recovered index are skipped.
+	data, _ := json.Marshal(&state)
+	s, rs = newServer()
+	if err := rs.stateMachine.Recovery(data); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(995); i <= 1001; i++ {
+		cmd := NewSetCommand("/f1", i, nullCAS)
+		if _, err := cmd.Apply(&testContext{server: rs, index: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, CAS, _ := s.DBGet("/f1"); value != uint64(1001) || CAS != 1002 {
+		t.Fatal("expected replayed commands to be skipped", value, CAS)
+	}
+
+	// snapshots saved without index are recovered as dictionary.
+	s, rs = newServer()
+	if err := rs.stateMachine.Recovery(state.Dict); err != nil {
+		t.Fatal(err)
+	} else if CAS := s.db.GetCAS(); CAS != 1001 || s.applyIndex != 0 {
+		t.Fatal("unexpected recovery", CAS, s.applyIndex)
+	}
+}
@@ -1,11 +1,14 @@
 package failsafe
 
+import "time"
+
 // Stats for failsafe dictionary.
 type Stats map[string]interface{}
 
 func NewStats() Stats {
 	stats := make(Stats)
 	stats["raftStateChange"] = 0
+	stats["raftElection"] = 0
 	stats["raftLeaderChange"] = 0
 	stats["raftTermChange"] = 0
 	stats["raftCommit"] = 0
@@ -14,5 +17,10 @@ func NewStats() Stats {
 	stats["raftHeartbeat"] = 0
 	stats["raftHeartbeatInterval"] = 0
 	stats["raftElectionTimeoutThreshold"] = 0
+	stats["raftLogSize"] = int64(0)
+	stats["snapshotCount"] = 0
+	stats["snapshotErrors"] = 0
+	stats["snapshotDuration"] = time.Duration(0)
+	stats["snapshotSize"] = int64(0)
 	return stats
 }
@@ -0,0 +1,176 @@
+// Health, readiness and status of server.
+//
+// /health tells that the process is alive, /ready tells that the server
+// can serve reads, that is, it knows the leader and has applied every
+// committed entry, and /status reports the raft and dictionary state of
+// the server. goraft applies entries as they are committed, applied index
+// is the index of the last entry applied on the dictionary, recorded after
+// the command is applied, refer Server.apply. Entries recovered from a
+// snapshot are not applied one by one, hence after recovering a snapshot
+// applied index follows the commit index, until the next entry is applied.
+// Probe handlers are registered before raft-server is started, so that a
+// server joining the cluster is alive.
+
+package failsafe
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrorNotReady when server does not know the leader or is yet to apply
+// committed entries.
+var ErrorNotReady = fmt.Errorf("failsafe.errorNotReady")
+
+// Status of server.
+type Status struct {
+	Name         string   `json:"name"`
+	State        string   `json:"state"`
+	Term         uint64   `json:"term"`
+	CommitIndex  uint64   `json:"commitIndex"`
+	AppliedIndex uint64   `json:"appliedIndex"`
+	Leader       string   `json:"leader"`
+	LeaderAddr   string   `json:"leaderAddr"`
+	Members      []Member `json:"members"`
+	CAS          float64  `json:"CAS"`
+	// snapshot information.
+	SnapshotIndex uint64    `json:"snapshotIndex"`
+	SnapshotTime  time.Time `json:"snapshotTime"`
+	SnapshotSize  int64     `json:"snapshotSize"`
+}
+
+// GetStatus of this server.
+func (s *Server) GetStatus() Status {
+	leader := s.GetLeader()
+	status := Status{
+		Name:         s.raftServer.Name(),
+		State:        s.raftServer.State(),
+		Term:         s.raftServer.Term(),
+		CommitIndex:  s.raftServer.CommitIndex(),
+		AppliedIndex: s.getAppliedIndex(),
+		Leader:       leader[0],
+		LeaderAddr:   leader[1],
+		Members:      s.Members(),
+		CAS:          s.db.GetCAS(),
+	}
+	if s.isLearner() {
+		status.State = RoleLearner
+	}
+	status.SnapshotIndex, status.SnapshotTime = s.getSnapshotInfo()
+	status.SnapshotSize, _ = s.GetStats()["snapshotSize"].(int64)
+	return status
+}
+
+// Ready return nil if server knows the leader and has applied every
+// committed entry, learners are ready once they have bootstrapped from
+// the leader.
+func (s *Server) Ready() error {
+	if s.isLearner() {
+		s.learnerMu.Lock()
+		defer s.learnerMu.Unlock()
+		if s.leaderInfo[0] == "" || !s.bootstrapped {
+			return ErrorNotReady
+		}
+		return nil
+	}
+	switch {
+	case !s.raftServer.Running(), s.raftServer.Leader() == "":
+		return ErrorNotReady
+	case s.getAppliedIndex() < s.raftServer.CommitIndex():
+		return ErrorNotReady
+	}
+	return nil
+}
+
+// setAppliedIndex, applied index never goes back.
+func (s *Server) setAppliedIndex(index uint64) {
+	s.statsMu.Lock()
+	if index > s.appliedIndex {
+		s.appliedIndex = index
+	}
+	s.statsMu.Unlock()
+}
+
+func (s *Server) getAppliedIndex() uint64 {
+	s.statsMu.Lock()
+	recovered := s.recovered
+	s.statsMu.Unlock()
+	if recovered {
+		s.syncAppliedIndex()
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.appliedIndex
+}
+
+// syncAppliedIndex with commit index, when every committed entry is known
+// to be applied.
+func (s *Server) syncAppliedIndex() {
+	index := s.raftServer.CommitIndex()
+	s.setAppliedIndex(index)
+	s.applyMu.Lock()
+	if index > s.applyIndex {
+		s.applyIndex = index
+	}
+	s.applyMu.Unlock()
+}
+
+// setRecovered when dictionary is recovered from a snapshot, reset when
+// the next entry is applied.
+func (s *Server) setRecovered(recovered bool) {
+	s.statsMu.Lock()
+	s.recovered = recovered
+	s.statsMu.Unlock()
+}
+
+// healthHandler replies as long as the process is alive.
+func (s *Server) healthHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	m := map[string]interface{}{"status": "ok", "err": "", "code": ""}
+	writeJSON(w, req, m)
+}
+
+// readyHandler replies 503 Service Unavailable until server is ready.
+func (s *Server) readyHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	err := s.Ready()
+	m := map[string]interface{}{
+		"ready": err == nil, "err": errorString(err), "code": errorCode(err),
+	}
+	writeJSON(w, req, m)
+}
+
+// statusHandler replies with the status of server.
+func (s *Server) statusHandler(w http.ResponseWriter, req *http.Request) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%v, error: %v", s.logPrefix, r)
+		}
+	}()
+
+	tracef("%v, %v %q\n", s.logPrefix, req.Method, req.URL)
+	if req.Method != "GET" {
+		msg := fmt.Sprintf("method %q not allowed", req.Method)
+		http.Error(w, msg, http.StatusMethodNotAllowed)
+		return
+	}
+	m := map[string]interface{}{"status": s.GetStatus(), "err": "", "code": ""}
+	writeJSON(w, req, m)
+}
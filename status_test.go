@@ -0,0 +1,107 @@
+package failsafe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goraft/raft"
+)
+
+func TestHealthReady(t *testing.T) {
+	sd, err := NewSafeDict(smallJSON, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: sd, learner: true}
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	s.healthHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status", w.Code)
+	}
+
+	// learner is ready once it knows the leader and has bootstrapped.
+	req = httptest.NewRequest("GET", "/ready", nil)
+	w = httptest.NewRecorder()
+	s.readyHandler(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatal("unexpected status", w.Code)
+	} else if body := w.Body.String(); body != `{"code":"not-ready","err":"failsafe.errorNotReady","ready":false}` {
+		t.Fatal("unexpected body", body)
+	}
+	s.leaderInfo = [2]string{"n1", "http://n1"}
+	s.setBootstrapped(true)
+	w = httptest.NewRecorder()
+	s.readyHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status", w.Code)
+	}
+
+	// applied index never goes back.
+	s.setAppliedIndex(10)
+	s.setAppliedIndex(5)
+	if index := s.getAppliedIndex(); index != 10 {
+		t.Fatal("unexpected applied index", index)
+	}
+}
+
+func TestAppliedIndexRecovery(t *testing.T) {
+	leader, _ := NewSafeDict(smallJSON, true)
+	leader.Set("/eyeColor", "green", nullCAS)
+	data, err := leader.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, _ := NewSafeDict(nil, true)
+	raftServer := &testRaftServer{commitIndex: 20}
+	s := &Server{db: sd, raftServer: raftServer}
+
+	// snapshot recovery raises no commit events.
+	sm := &stateMachine{s: s}
+	if err := sm.Recovery(data); err != nil {
+		t.Fatal(err)
+	} else if index := s.getAppliedIndex(); index != 20 {
+		t.Fatal("unexpected applied index", index)
+	} else if s.applyIndex != 20 {
+		t.Fatal("unexpected apply index", s.applyIndex)
+	}
+
+	// applied index moves once the next command is applied.
+	s.metrics = newMetrics()
+	raftServer.commitIndex = 25
+	applied, _ := s.apply(&testContext{index: 21})
+	s.statsMu.Lock()
+	recovered, index := s.recovered, s.appliedIndex
+	s.statsMu.Unlock()
+	if !recovered || index != 20 {
+		t.Fatal("unexpected applied index while applying", recovered, index)
+	}
+	applied()
+	if index := s.getAppliedIndex(); index != 21 {
+		t.Fatal("unexpected applied index", index)
+	}
+}
+
+func TestStatusWhileSnapshot(t *testing.T) {
+	sd, _ := NewSafeDict(nil, true)
+	rs := &testRaftServer{name: "n1", leader: "n1", state: raft.Leader, commitIndex: 7}
+	s := &Server{db: sd, raftServer: rs, stats: NewStats()}
+	s.setSnapshotInfo(5, time.Now())
+
+	// snapshot in progress.
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	statusch := make(chan Status, 1)
+	go func() { statusch <- s.GetStatus() }()
+	select {
+	case status := <-statusch:
+		if status.SnapshotIndex != 5 || status.CommitIndex != 7 {
+			t.Fatal("unexpected status", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected status while taking snapshot")
+	}
+}
@@ -0,0 +1,188 @@
+// Leadership transfer.
+//
+// goraft does not support handing over leadership, hence transfer is
+// approximated. Leader waits for the target to apply its log upto
+// leader's commit index and asks the target to campaign, through the
+// internal `/admin/campaign` endpoint. Target lowers its election timeout
+// so that it is the first to time out, then leader steps down by stopping
+// the server, meant for taking the leader down, say for an upgrade. Raft
+// elects only up-to-date candidates, so leadership might end up with
+// another node when target is lagging, in which case ErrorTransferFailed
+// is returned.
+
+package failsafe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/goraft/raft"
+)
+
+// ErrorTransferFailed when leadership could not be transferred to target.
+var ErrorTransferFailed = fmt.Errorf("failsafe.errorTransferFailed")
+
+// SetTransferOnStop to transfer leadership to the most recently active
+// peer, when Stop is called on the leader.
+func (s *Server) SetTransferOnStop(transfer bool) {
+	s.transferOnStop = transfer
+}
+
+// TransferLeadership to target peer and stop this server, shall be called
+// on the leader. Return once target is elected.
+func (s *Server) TransferLeadership(target string) (err error) {
+	peer, err := s.handover(target)
+	if err != nil {
+		return err
+	}
+	// step down, by stopping this server.
+	s.stopOnce.Do(func() { err = s.stop() })
+	if err != nil {
+		return err
+	}
+	return s.waitLeader(peer.ConnectionString, target)
+}
+
+// handover leadership to target, wait for it to catch up and ask it to
+// campaign, leader shall step down afterwards.
+func (s *Server) handover(target string) (*raft.Peer, error) {
+	if s.raftServer.State() != raft.Leader {
+		return nil, raft.NotLeaderError
+	}
+	peer, ok := s.raftServer.Peers()[target]
+	if !ok {
+		return nil, ErrorMemberNotFound
+	}
+	tracef("%v, transferring leadership to %q\n", s.logPrefix, target)
+
+	index, deadline := s.raftServer.CommitIndex(), time.Now().Add(transferTimeout)
+	for {
+		if status, err := getPeerStatus(peer.ConnectionString); err != nil {
+			return nil, err
+		} else if status.AppliedIndex >= index {
+			break
+		} else if time.Now().After(deadline) {
+			return nil, ErrorTransferFailed
+		}
+		time.Sleep(transferPollInterval)
+	}
+	if err := askCampaign(peer.ConnectionString, s.raftServer.Name()); err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// waitLeader until target, reachable at connectionString, is elected.
+func (s *Server) waitLeader(connectionString, target string) error {
+	deadline := time.Now().Add(transferTimeout)
+	for time.Now().Before(deadline) {
+		status, err := getPeerStatus(connectionString)
+		if err != nil {
+			return err
+		}
+		switch status.Leader {
+		case target:
+			return nil
+		case "", s.raftServer.Name(): // yet to time out on this server.
+		default:
+			return ErrorTransferFailed
+		}
+		time.Sleep(transferPollInterval)
+	}
+	return ErrorTransferFailed
+}
+
+// campaign for leadership by lowering the election timeout, so that this
+// server is the first to time out once the leader steps down. Election
+// timeout is restored after transferTimeout, campaigning again meanwhile
+// is a no-op.
+func (s *Server) campaign() {
+	s.campaignMu.Lock()
+	defer s.campaignMu.Unlock()
+	if s.campaigning {
+		return
+	}
+	s.campaigning = true
+	timeout := s.raftServer.ElectionTimeout()
+	s.raftServer.SetElectionTimeout(2 * s.raftServer.HeartbeatInterval())
+	go func() {
+		select {
+		case <-s.quitch:
+		case <-time.After(transferTimeout):
+		}
+		s.campaignMu.Lock()
+		s.raftServer.SetElectionTimeout(timeout)
+		s.campaigning = false
+		s.campaignMu.Unlock()
+	}()
+}
+
+// transferBeforeStop to the most recently active peer, if this server is
+// the leader, stopping the server steps down.
+func (s *Server) transferBeforeStop() {
+	if s.raftServer.State() != raft.Leader {
+		return
+	}
+	var target string
+	var lastActivity time.Time
+	for name, peer := range s.raftServer.Peers() {
+		if peer.LastActivity().After(lastActivity) {
+			target, lastActivity = name, peer.LastActivity()
+		}
+	}
+	if target == "" {
+		return
+	}
+	if _, err := s.handover(target); err != nil {
+		debugf("%v, transferring leadership to %q: %v\n", s.logPrefix, target, err)
+	}
+}
+
+// getPeerStatus return the status of peer.
+func getPeerStatus(connectionString string) (status Status, err error) {
+	htresp, err := http.Get(connectionString + "/status")
+	if err != nil {
+		return status, err
+	}
+	defer htresp.Body.Close()
+
+	var resp struct {
+		Status Status `json:"status"`
+		Err    string `json:"err"`
+		Code   string `json:"code"`
+	}
+	if err := json.NewDecoder(htresp.Body).Decode(&resp); err != nil {
+		return status, err
+	} else if resp.Err != "" {
+		return status, codeError(resp.Code, resp.Err)
+	}
+	return resp.Status, nil
+}
+
+// askCampaign asks peer to campaign for leadership, on behalf of leader.
+func askCampaign(connectionString, leader string) error {
+	uri := connectionString + "/admin/campaign"
+	body, err := json.Marshal(map[string]interface{}{"leader": leader})
+	if err != nil {
+		return err
+	}
+	htresp, err := http.Post(uri, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer htresp.Body.Close()
+
+	var resp struct {
+		Err  string `json:"err"`
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(htresp.Body).Decode(&resp); err != nil {
+		return err
+	} else if resp.Err != "" {
+		return codeError(resp.Code, resp.Err)
+	}
+	return nil
+}
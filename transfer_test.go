@@ -0,0 +1,149 @@
+package failsafe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goraft/raft"
+)
+
+func TestTransferPeerCalls(t *testing.T) {
+	var campaigns int
+	peer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/status":
+				status := Status{Name: "n2", AppliedIndex: 42}
+				m := map[string]interface{}{"status": status, "err": "", "code": ""}
+				writeJSON(w, req, m)
+			case "/admin/campaign":
+				var err error
+				jsonreq, _ := parseRequest(req)
+				if jsonreq["leader"] != "n1" {
+					err = raft.NotLeaderError
+				} else if campaigns++; campaigns > 1 {
+					err = ErrorTransferFailed
+				}
+				m := map[string]interface{}{"err": errorString(err), "code": errorCode(err)}
+				writeJSON(w, req, m)
+			}
+		}))
+	defer peer.Close()
+
+	if status, err := getPeerStatus(peer.URL); err != nil {
+		t.Fatal(err)
+	} else if status.AppliedIndex != 42 {
+		t.Fatal("unexpected applied index", status.AppliedIndex)
+	}
+	if err := askCampaign(peer.URL, "n1"); err != nil {
+		t.Fatal(err)
+	} else if campaigns != 1 {
+		t.Fatal("expected campaign", campaigns)
+	}
+	if err := askCampaign(peer.URL, "n1"); err != ErrorTransferFailed {
+		t.Fatalf("expected %v, got %v", ErrorTransferFailed, err)
+	}
+	if err := askCampaign(peer.URL, "n3"); err != raft.NotLeaderError {
+		t.Fatalf("expected %v, got %v", raft.NotLeaderError, err)
+	}
+}
+
+func TestTransferLeadership(t *testing.T) {
+	rs := &testRaftServer{
+		name: "n1", leader: "n1", state: raft.Leader, commitIndex: 10,
+	}
+	var statuses, campaigns int
+	peer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/status":
+				// peer catches up with leader's commit index on third poll,
+				// and is elected on second poll after campaign.
+				statuses++
+				status := Status{
+					Name: "n2", AppliedIndex: uint64(7 + statuses), Leader: "n1",
+				}
+				if campaigns > 0 && statuses > 4 {
+					status.Leader = "n2"
+				}
+				m := map[string]interface{}{"status": status, "err": "", "code": ""}
+				writeJSON(w, req, m)
+			case "/admin/campaign":
+				if jsonreq, _ := parseRequest(req); jsonreq["leader"] != "n1" {
+					t.Error("expected campaign from leader", jsonreq)
+				}
+				campaigns++
+				writeJSON(w, req, map[string]interface{}{"err": "", "code": ""})
+			}
+		}))
+	defer peer.Close()
+	rs.peers = map[string]*raft.Peer{"n2": {Name: "n2", ConnectionString: peer.URL}}
+	s := &Server{
+		raftServer: rs, metrics: newMetrics(), stats: NewStats(),
+		quitch: make(chan struct{}),
+	}
+
+	if err := s.TransferLeadership("n3"); err != ErrorMemberNotFound {
+		t.Fatalf("expected %v, got %v", ErrorMemberNotFound, err)
+	}
+	if err := s.TransferLeadership("n2"); err != nil {
+		t.Fatal(err)
+	} else if statuses != 5 || campaigns != 1 {
+		t.Fatal("expected campaign after catching up", statuses, campaigns)
+	} else if rs.stops != 1 || rs.State() != raft.Stopped {
+		t.Fatal("expected leader to step down by stopping", rs.stops, rs.State())
+	}
+	if err := s.TransferLeadership("n2"); err != raft.NotLeaderError {
+		t.Fatalf("expected %v, got %v", raft.NotLeaderError, err)
+	} else if err := s.Stop(); err != nil || rs.stops != 1 {
+		t.Fatal("expected server to be stopped once", err, rs.stops)
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	rs := &testRaftServer{name: "n2", state: raft.Follower, electionTimeout: time.Second}
+	s := &Server{raftServer: rs, quitch: make(chan struct{})}
+	s.campaign()
+	s.campaign() // shall not save the lowered timeout.
+	if timeout := rs.ElectionTimeout(); timeout != 2*rs.HeartbeatInterval() {
+		t.Fatal("expected lowered election timeout", timeout)
+	}
+	campaigning := func() bool {
+		s.campaignMu.Lock()
+		defer s.campaignMu.Unlock()
+		return s.campaigning
+	}
+	close(s.quitch)
+	for i := 0; i < 100 && campaigning(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if timeout := rs.ElectionTimeout(); timeout != time.Second {
+		t.Fatal("expected election timeout to be restored", timeout)
+	}
+}
+
+func TestCampaignHandler(t *testing.T) {
+	rs := &testRaftServer{
+		name: "n2", leader: "n1", state: raft.Follower,
+		electionTimeout: time.Second,
+	}
+	s := &Server{raftServer: rs, quitch: make(chan struct{})}
+	defer close(s.quitch)
+	server := httptest.NewServer(http.HandlerFunc(s.campaignHandler))
+	defer server.Close()
+
+	if err := askCampaign(server.URL, "n3"); err != raft.NotLeaderError {
+		t.Fatalf("expected %v, got %v", raft.NotLeaderError, err)
+	} else if err := askCampaign(server.URL, ""); err != raft.NotLeaderError {
+		t.Fatalf("expected %v, got %v", raft.NotLeaderError, err)
+	} else if timeout := rs.ElectionTimeout(); timeout != time.Second {
+		t.Fatal("expected election timeout to be unchanged", timeout)
+	}
+	if err := askCampaign(server.URL, "n1"); err != nil {
+		t.Fatal(err)
+	} else if timeout := rs.ElectionTimeout(); timeout != 2*rs.HeartbeatInterval() {
+		t.Fatal("expected lowered election timeout", timeout)
+	}
+}
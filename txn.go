@@ -0,0 +1,148 @@
+// Multi-operation transactions on SafeDict, guarded by compare conditions.
+//
+// A transaction is evaluated and applied atomically, if all compare
+// conditions hold good `Then` operations are applied, otherwise `Else`
+// operations are applied.
+//
+// Example {
+//      txn := &Txn{
+//          Compare: []TxnCompare{CompareValue("/index/state", "ready")},
+//          Then:    []TxnOp{OpSet("/index/node", "node1")},
+//          Else:    []TxnOp{OpDelete("/index/node")},
+//      }
+// }
+
+package failsafe
+
+// TxnCompare is a guard condition evaluated by a transaction. Target can be
+// one of "exists", "value", "version" or "CAS".
+type TxnCompare struct {
+	Target string      `json:"target"`
+	Path   string      `json:"path"`
+	Value  interface{} `json:"value"`
+}
+
+// TxnOp is an operation applied by a transaction. Op can be one of "set"
+// or "delete".
+type TxnOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Txn is a list of compare conditions and operations to apply when the
+// conditions succeed or fail.
+type Txn struct {
+	Compare []TxnCompare `json:"compare"`
+	Then    []TxnOp      `json:"then"`
+	Else    []TxnOp      `json:"else"`
+}
+
+// TxnResult is the outcome of a transaction.
+type TxnResult struct {
+	Succeeded bool    `json:"succeeded"`
+	CAS       float64 `json:"CAS"`
+}
+
+// CompareExists succeeds if a field exists at path.
+func CompareExists(path string) TxnCompare {
+	return TxnCompare{Target: "exists", Path: path, Value: true}
+}
+
+// CompareMissing succeeds if no field exists at path.
+func CompareMissing(path string) TxnCompare {
+	return TxnCompare{Target: "exists", Path: path, Value: false}
+}
+
+// CompareValue succeeds if field at path is equal to value.
+func CompareValue(path string, value interface{}) TxnCompare {
+	return TxnCompare{Target: "value", Path: path, Value: value}
+}
+
+// CompareVersion succeeds if field at path was last modified at version,
+// version of a missing field is nullCAS.
+func CompareVersion(path string, version float64) TxnCompare {
+	return TxnCompare{Target: "version", Path: path, Value: version}
+}
+
+// CompareCAS succeeds if dictionary's CAS is equal to CAS.
+func CompareCAS(CAS float64) TxnCompare {
+	return TxnCompare{Target: "CAS", Value: CAS}
+}
+
+// OpSet sets value at path.
+func OpSet(path string, value interface{}) TxnOp {
+	return TxnOp{Op: "set", Path: path, Value: value}
+}
+
+// OpDelete deletes field at path, deleting a missing field is a no-op.
+func OpDelete(path string) TxnOp {
+	return TxnOp{Op: "delete", Path: path}
+}
+
+// evaluate compare conditions on dictionary.
+func (txn *Txn) evaluate(sd *SafeDict) (bool, error) {
+	for _, cmp := range txn.Compare {
+		switch cmp.Target {
+		case "exists":
+			_, ok := sd.lookup(cmp.Path)
+			if ok != (cmp.Value != false) {
+				return false, nil
+			}
+
+		case "value":
+			value, ok := sd.lookup(cmp.Path)
+			if !ok || !jsonEqual(value, cmp.Value) {
+				return false, nil
+			}
+
+		case "version": // version of a missing field is zero.
+			version := nullCAS
+			if _, ok := sd.lookup(cmp.Path); ok {
+				version = sd.revision(cmp.Path).Modify
+			}
+			if !jsonEqual(version, cmp.Value) {
+				return false, nil
+			}
+
+		case "CAS":
+			if !jsonEqual(sd.CAS, cmp.Value) {
+				return false, nil
+			}
+
+		default:
+			return false, ErrorInvalidTxn
+		}
+	}
+	return true, nil
+}
+
+// apply operation on m, similar to SafeDict's Set() and Delete(), and return
+// the updated dictionary. m is not modified.
+func (op *TxnOp) apply(m map[string]interface{}) (map[string]interface{}, error) {
+	var doc interface{}
+	var err error
+
+	switch op.Op {
+	case "set":
+		if doc, err = patchReplace(m, op.Path, op.Value); err != nil {
+			return nil, err
+		}
+
+	case "delete":
+		if op.Path == "" {
+			return make(map[string]interface{}), nil
+		} else if _, ok := lookupField(m, op.Path); !ok {
+			return m, nil // nothing to delete, same as Delete().
+		} else if doc, err = deleteField(m, op.Path); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, ErrorInvalidTxn
+	}
+	if m, ok := doc.(map[string]interface{}); ok {
+		return m, nil
+	}
+	return nil, ErrorInvalidType
+}
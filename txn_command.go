@@ -0,0 +1,32 @@
+package failsafe
+
+import (
+	"github.com/goraft/raft"
+)
+
+// TxnCommand to apply a transaction on SafeDict.
+type TxnCommand struct {
+	Txn *Txn `json:"txn"`
+}
+
+// NewTxnCommand creates a new instance of TxnCommand.
+func NewTxnCommand(txn *Txn) *TxnCommand {
+	return &TxnCommand{txn}
+}
+
+// CommandName implements raft.Command interface.
+func (c *TxnCommand) CommandName() string {
+	return "txn"
+}
+
+// Apply implements raft.CommandApply interface.
+func (c *TxnCommand) Apply(context raft.Context) (interface{}, error) {
+	s := context.Server().Context().(*Server)
+	applied, ok := s.apply(context)
+	defer applied()
+	if !ok {
+		return nil, nil
+	}
+	result, err := s.db.Txn(c.Txn)
+	return result, err
+}
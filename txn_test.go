@@ -0,0 +1,136 @@
+package failsafe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTxnSafeDict(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"state": "ready", "nodes": {}}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := &Txn{
+		Compare: []TxnCompare{
+			CompareValue("/state", "ready"),
+			CompareMissing("/nodes/n1"),
+			CompareCAS(1),
+		},
+		Then: []TxnOp{OpSet("/nodes/n1", "up"), OpSet("/state", "busy")},
+		Else: []TxnOp{OpSet("/conflict", true)},
+	}
+	if result, err := sd.Txn(txn); err != nil {
+		t.Fatal(err)
+	} else if result.Succeeded == false {
+		t.Fatal("expected transaction to succeed")
+	} else if result.CAS != 2 {
+		t.Fatal("expected CAS as 2", result.CAS)
+	}
+	ref := map[string]interface{}{
+		"state": "busy", "nodes": map[string]interface{}{"n1": "up"},
+	}
+	if reflect.DeepEqual(sd.m, ref) == false {
+		t.Fatal("failed txn", sd.m)
+	}
+
+	// compare fails, else branch is applied.
+	if result, err := sd.Txn(txn); err != nil {
+		t.Fatal(err)
+	} else if result.Succeeded {
+		t.Fatal("expected transaction to fail")
+	} else if result.CAS != 3 {
+		t.Fatal("expected CAS as 3", result.CAS)
+	} else if val, _, _ := sd.Get("/conflict"); val != true {
+		t.Fatal("failed else branch", val)
+	}
+
+	// failing operation leaves dictionary untouched.
+	txn = &Txn{
+		Compare: []TxnCompare{CompareExists("/nodes/n1")},
+		Then:    []TxnOp{OpDelete("/nodes/n1"), OpSet("/missing/n2", "up")},
+	}
+	if _, err := sd.Txn(txn); err == nil {
+		t.Fatal("expected error setting field under a missing field")
+	} else if val, _, _ := sd.Get("/nodes/n1"); val != "up" {
+		t.Fatal("failed txn is not atomic", val)
+	} else if sd.GetCAS() != 3 {
+		t.Fatal("failed txn shall not increment CAS")
+	}
+
+	// no operations to apply.
+	txn = &Txn{Compare: []TxnCompare{CompareMissing("/nodes/n1")}}
+	if result, err := sd.Txn(txn); err != nil {
+		t.Fatal(err)
+	} else if result.Succeeded || result.CAS != 3 {
+		t.Fatal("unexpected result", result)
+	}
+
+	txn = &Txn{Compare: []TxnCompare{{Target: "frob"}}}
+	if _, err := sd.Txn(txn); err != ErrorInvalidTxn {
+		t.Fatal("expected ErrorInvalidTxn", err)
+	}
+}
+
+func TestTxnDeleteMissing(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": 1}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deleting a missing field is a no-op, same as Delete().
+	txn := &Txn{Then: []TxnOp{OpDelete("/missing")}}
+	if result, err := sd.Txn(txn); err != nil {
+		t.Fatal(err)
+	} else if !result.Succeeded || result.CAS != 1 {
+		t.Fatal("unexpected result", result)
+	} else if changes, _ := sd.GetChangesSince(0); len(changes) != 0 {
+		t.Fatal("unexpected changes", changes)
+	}
+	if CAS, err := sd.Delete("/missing", nullCAS); err != nil || CAS != 1 {
+		t.Fatal("unexpected delete", CAS, err)
+	}
+
+	// along with other operations.
+	txn = &Txn{Then: []TxnOp{OpDelete("/missing"), OpDelete("/a")}}
+	if result, err := sd.Txn(txn); err != nil {
+		t.Fatal(err)
+	} else if result.CAS != 2 {
+		t.Fatal("unexpected result", result)
+	} else if !reflect.DeepEqual(sd.m, map[string]interface{}{}) {
+		t.Fatal("unexpected dictionary", sd.m)
+	}
+}
+
+func TestTxnCompareVersion(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"a": 1, "b": 2}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.Set("/a", float64(10), nullCAS) // CAS 2
+	sd.Set("/b", float64(20), nullCAS) // CAS 3
+
+	// version of a field is not changed by unrelated writes.
+	txn := &Txn{
+		Compare: []TxnCompare{CompareVersion("/a", 2), CompareVersion("/c", 0)},
+		Then:    []TxnOp{OpSet("/c", "x")},
+	}
+	if result, err := sd.Txn(txn); err != nil {
+		t.Fatal(err)
+	} else if result.Succeeded == false || result.CAS != 4 {
+		t.Fatal("unexpected result", result)
+	}
+
+	// stale version fails the compare.
+	txn = &Txn{
+		Compare: []TxnCompare{CompareVersion("/b", 2)},
+		Then:    []TxnOp{OpDelete("/b")},
+	}
+	if result, err := sd.Txn(txn); err != nil {
+		t.Fatal(err)
+	} else if result.Succeeded {
+		t.Fatal("expected transaction to fail")
+	} else if val, _, _ := sd.Get("/b"); val != float64(20) {
+		t.Fatal("unexpected value", val)
+	}
+}
@@ -0,0 +1,117 @@
+// Watch for changes in SafeDict.
+//
+// Every write to the dictionary is notified to watchers as one or more
+// Change, all changes from the same write share the same CAS. A watcher
+// receives changes to fields under its jsonpointer prefix, and changes to
+// parent fields of the prefix, which might have replaced the watched
+// field as a whole.
+
+package failsafe
+
+import (
+	"strings"
+)
+
+// Change to a field in SafeDict. Op can be one of "set", "delete",
+// "patch" or "merge", fields removed by JSON Patch are notified as
+// "delete". OldValue and NewValue are nil if the field was missing before
+// or after the change.
+type Change struct {
+	CAS      float64     `json:"CAS"`
+	Path     string      `json:"path"`
+	Op       string      `json:"op"`
+	OldValue interface{} `json:"oldValue"`
+	NewValue interface{} `json:"newValue"`
+}
+
+type watcher struct {
+	prefix string
+	ch     chan Change
+}
+
+// match return true if change to field at path shall be notified to
+// watcher.
+func (w *watcher) match(path string) bool {
+	switch {
+	case path == w.prefix, w.prefix == "", path == "":
+		return true
+	case strings.HasPrefix(path, w.prefix+"/"):
+		return true
+	case strings.HasPrefix(w.prefix, path+"/"):
+		return true
+	}
+	return false
+}
+
+// Watch for changes to fields under jsonpointer prefix, that happened after
+// fromCAS. If fromCAS is nullCAS, only changes after this call are
+// notified, otherwise changes are replayed from history and ErrorCompacted
+// is returned if they are no longer remembered. Changes are delivered on
+// returned channel until cancel is called. Watchers that are too slow to
+// receive changes are dropped by closing the channel, they can watch again
+// from the last received CAS.
+func (sd *SafeDict) Watch(prefix string, fromCAS float64) (ch <-chan Change, cancel func(), err error) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	var backlog []Change
+	if fromCAS != nullCAS && fromCAS < sd.CAS {
+		if backlog, err = sd.history.since(fromCAS); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if sd.watchers == nil {
+		sd.watchers = make(map[uint64]*watcher)
+	}
+	sd.watchid++
+	id := sd.watchid
+	w := &watcher{prefix: prefix}
+	w.ch = make(chan Change, watchChanSize+len(backlog))
+	for _, change := range backlog {
+		if w.match(change.Path) {
+			w.ch <- change
+		}
+	}
+	sd.watchers[id] = w
+	cancel = func() {
+		sd.mu.Lock()
+		defer sd.mu.Unlock()
+		if _, ok := sd.watchers[id]; ok {
+			delete(sd.watchers, id)
+			close(w.ch)
+		}
+	}
+	return w.ch, cancel, nil
+}
+
+// notify changes to watchers, never blocks.
+func (sd *SafeDict) notify(changes []Change) {
+	for id, w := range sd.watchers {
+	loop:
+		for _, change := range changes {
+			if !w.match(change.Path) {
+				continue
+			}
+			select {
+			case w.ch <- change:
+			default:
+				delete(sd.watchers, id)
+				close(w.ch)
+				break loop
+			}
+		}
+	}
+}
+
+// appendChange for field at path, changes to the same field are notified
+// only once.
+func appendChange(changes []Change, path, op string) []Change {
+	for i := range changes {
+		if changes[i].Path == path {
+			changes[i].Op = op
+			return changes
+		}
+	}
+	return append(changes, Change{Path: path, Op: op})
+}
@@ -0,0 +1,86 @@
+package failsafe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWatchSafeDict(t *testing.T) {
+	sd, err := NewSafeDict([]byte(`{"nodes": {"n1": "up"}, "x": 1}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, cancel, err := sd.Watch("/nodes", nullCAS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd.Set("/x", float64(2), nullCAS) // not watched
+	sd.Set("/nodes/n2", "up", nullCAS)
+	sd.Delete("/nodes/n1", nullCAS)
+	ops := []PatchOp{
+		{Op: "move", From: "/nodes/n2", Path: "/nodes/n3"},
+		{Op: "test", Path: "/x", Value: float64(2)},
+	}
+	sd.Patch(ops, nullCAS)
+	sd.Set("", map[string]interface{}{}, nullCAS)
+
+	refs := []Change{
+		{CAS: 3, Path: "/nodes/n2", Op: "set", NewValue: "up"},
+		{CAS: 4, Path: "/nodes/n1", Op: "delete", OldValue: "up"},
+		{CAS: 5, Path: "/nodes/n2", Op: "delete", OldValue: "up"},
+		{CAS: 5, Path: "/nodes/n3", Op: "patch", NewValue: "up"},
+		{CAS: 6, Path: "", Op: "set",
+			OldValue: map[string]interface{}{
+				"nodes": map[string]interface{}{"n3": "up"}, "x": float64(2),
+			},
+			NewValue: map[string]interface{}{},
+		},
+	}
+	for _, ref := range refs {
+		if change := <-ch; reflect.DeepEqual(change, ref) == false {
+			t.Fatalf("expected %v, got %v", ref, change)
+		}
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("expected channel to be closed")
+	}
+	cancel()
+
+	sd.SetHistorySize(0)
+	if _, _, err := sd.Watch("", 2); err != ErrorCompacted {
+		t.Fatal("expected ErrorCompacted", err)
+	}
+}
+
+func TestWatchSlow(t *testing.T) {
+	sd, _ := NewSafeDict(nil, true)
+	ch, cancel, _ := sd.Watch("", sd.GetCAS())
+	defer cancel()
+	for i := 0; i <= watchChanSize; i++ {
+		sd.Set("/key", float64(i), nullCAS)
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != watchChanSize {
+		t.Fatal("expected slow watcher to be dropped", n)
+	}
+}
+
+func TestWatcherMatch(t *testing.T) {
+	w := &watcher{prefix: "/a/b"}
+	for _, path := range []string{"", "/a", "/a/b", "/a/b/c"} {
+		if w.match(path) == false {
+			t.Fatalf("expected %q to match", path)
+		}
+	}
+	for _, path := range []string{"/ab", "/a/bc", "/a/c", "/b"} {
+		if w.match(path) {
+			t.Fatalf("expected %q not to match", path)
+		}
+	}
+}